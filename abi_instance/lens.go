@@ -1,9 +1,12 @@
 package abi_instance
 
 import (
+	_ "embed"
+	"strings"
+
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"strings"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 const (
@@ -11,9 +14,22 @@ const (
 	LensAddressHex = "0x2511107146BB1908434E92FF7D985C4B7e2Fb08a" // TODO: Replace with the actual Lens contract address
 )
 
+// LensRuntimeHex is the runtime bytecode of UniswapV3Lens.sol, checked in and rebuilt with `make lens`.
+// It is injected at LensOverrideAddress through eth_call state override, so the lens
+// works on chains where it is not deployed.
+//
+//go:embed UniswapV3Lens.bin-runtime
+var LensRuntimeHex string
+
+const (
+	LensOverrideAddressHex = "0x00000000000000000000000000000000004c656e" // scratch address, no code on any chain
+)
+
 var (
-	LensABI     *abi.ABI
-	LensAddress = common.HexToAddress(LensAddressHex)
+	LensABI             *abi.ABI
+	LensAddress         = common.HexToAddress(LensAddressHex)
+	LensOverrideAddress = common.HexToAddress(LensOverrideAddressHex)
+	LensRuntimeCode     []byte
 )
 
 func init() {
@@ -22,4 +38,11 @@ func init() {
 		panic(err)
 	}
 	LensABI = &lensAbi
+
+	if code := strings.TrimSpace(LensRuntimeHex); code != "" {
+		LensRuntimeCode, err = hexutil.Decode("0x" + strings.TrimPrefix(code, "0x"))
+		if err != nil {
+			panic(err)
+		}
+	}
 }
@@ -0,0 +1,32 @@
+package abi_instance
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	Multicall3ABIJson    = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+	Multicall3AddressHex = "0xcA11bde05977b3631167028862bE2a173976CA11" // same address on all major EVM chains
+)
+
+type Multicall3Call struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+var (
+	Multicall3ABI     *abi.ABI
+	Multicall3Address = common.HexToAddress(Multicall3AddressHex)
+)
+
+func init() {
+	multicall3Abi, err := abi.JSON(strings.NewReader(Multicall3ABIJson))
+	if err != nil {
+		panic(err)
+	}
+	Multicall3ABI = &multicall3Abi
+}
@@ -39,6 +39,8 @@ const (
 	SwapTopic0Hex = "0x19b47279256b2a23a1665c810c8d55a1758940ee09377d4f8d26497a3577dc83"
 	MintTopic0Hex = "0x7a53080ba414158be7ec69b987b5fb7d07dee101fe85488f0853ae16239d0bde"
 	BurnTopic0Hex = "0x0c396cd989a39f4459b5fa1aed6a9a8dcdbc45908acfd67e028cd568da98982c"
+
+	InitializeTopic0Hex = "0x98636036cb66a9c19a37435efc1e90142190214e8abeb821bdba3f2990dd4c95"
 )
 
 var (
@@ -52,6 +54,9 @@ var (
 
 	BurnTopic0 = common.HexToHash(BurnTopic0Hex)
 	BurnEvent  *abi.Event
+
+	InitializeTopic0 = common.HexToHash(InitializeTopic0Hex)
+	InitializeEvent  *abi.Event
 )
 
 func init() {
@@ -78,4 +83,10 @@ func init() {
 		panic(err)
 	}
 	BurnEvent = burnEvent
+
+	initializeEvent, err := poolAbi.EventByID(InitializeTopic0)
+	if err != nil {
+		panic(err)
+	}
+	InitializeEvent = initializeEvent
 }
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -15,7 +18,11 @@ type APIServer interface {
 }
 
 type apiServer struct {
+	addr            string
+	adminAddr       string // empty disables the admin routes
 	poolStateGetter PoolStateGetter
+	db              DB
+	checkpointer    *Checkpointer
 }
 
 func parseParams(r *http.Request, requiredParams []string) (map[string]string, error) {
@@ -37,6 +44,8 @@ type PoolStateParams struct {
 	TickOffset uint64         `json:"tick_offset"`
 	Type       string         `json:"type"`
 	Format     string         `json:"format"`
+	Height     uint64         `json:"height"`       // 0 means the latest state
+	At         *uint64        `json:"at,omitempty"` // unix seconds, resolved to Height by the server, nil if not given
 }
 
 const (
@@ -44,6 +53,14 @@ const (
 	ParamTickOffset = "tick_offset"
 	ParamType       = "type"
 	ParamFormat     = "format"
+	ParamHeight     = "height" // optional
+	ParamAt         = "at"     // optional, RFC3339 or unix seconds
+)
+
+// resolved height and its block time are echoed in every pool state response
+const (
+	HeaderBlockHeight    = "X-Block-Height"
+	HeaderBlockTimestamp = "X-Block-Timestamp"
 )
 
 const (
@@ -72,16 +89,59 @@ func FromHttpRequest(r *http.Request) (*PoolStateParams, error) {
 		return nil, err
 	}
 
+	query := r.URL.Query()
+	var height uint64
+	if value := query.Get(ParamHeight); value != "" {
+		height, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// at=0 is a time like any other, only a missing at is unset
+	var at *uint64
+	if query.Has(ParamAt) {
+		if query.Has(ParamHeight) {
+			return nil, errors.New("height and at are exclusive")
+		}
+
+		t, err := parseTime(query.Get(ParamAt))
+		if err != nil {
+			return nil, err
+		}
+		at = &t
+	}
+
 	p := &PoolStateParams{
 		Address:    common.HexToAddress(kv[ParamAddress]),
 		TickOffset: tickOffset,
 		Type:       kv[ParamType],
 		Format:     kv[ParamFormat],
+		Height:     height,
+		At:         at,
 	}
 	p.arrange()
 	return p, nil
 }
 
+// parseTime accepts RFC3339 or unix seconds
+func parseTime(value string) (uint64, error) {
+	if unix, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return unix, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s: %w", value, err)
+	}
+
+	if t.Unix() < 0 {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+
+	return uint64(t.Unix()), nil
+}
+
 func (p *PoolStateParams) arrange() {
 	if p.Type != ParamTypeLiquidity && p.Type != ParamTypeTokenAmount && p.Type != ParamTypeTokenAmountDetail {
 		p.Type = ParamTypeLiquidity
@@ -105,14 +165,45 @@ func (a *apiServer) HandlerPoolState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	poolState, err := a.poolStateGetter.GetPoolState(params.Address)
+	if params.At != nil {
+		params.Height, err = a.poolStateGetter.GetHeightAt(*params.At)
+		if err != nil {
+			if errors.Is(err, ErrTimeNotIndexed) || errors.Is(err, ErrHeightNotIngested) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(fmt.Sprintf("resolve time error: %v", err)))
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("resolve time error: %v", err)))
+			return
+		}
+	}
+
+	var poolState *PoolState
+	if params.Height == 0 && params.Type != ParamTypeLiquidity {
+		poolState, err = a.poolStateGetter.GetPoolStateInRange(params.Address, int32(params.TickOffset))
+	} else if params.Height == 0 {
+		poolState, err = a.poolStateGetter.GetPoolState(params.Address)
+	} else {
+		poolState, err = a.poolStateGetter.GetPoolStateAt(params.Address, params.Height)
+	}
 	if err != nil {
+		if errors.Is(err, ErrHeightPruned) || errors.Is(err, ErrHeightNotIngested) || errors.Is(err, ErrNoPoolState) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("get pool states error: %v", err)))
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("get pool states error: %v", err)))
 		return
 	}
 	Log.Info(fmt.Sprintf("get pool states: %s", poolState))
 
+	w.Header().Set(HeaderBlockHeight, poolState.Global.Height.String())
+	if poolState.Global.Timestamp != 0 {
+		w.Header().Set(HeaderBlockTimestamp, strconv.FormatUint(poolState.Global.Timestamp, 10))
+	}
+
 	switch params.Type {
 	case ParamTypeLiquidity:
 		w.Header().Add("Content-Type", "application/json")
@@ -124,7 +215,11 @@ func (a *apiServer) HandlerPoolState(w http.ResponseWriter, r *http.Request) {
 		tickSpacing := int32(poolState.Global.TickSpacing.Int64())
 		fromTick, toTick := CalculateTickRange(currentTick, int32(params.TickOffset), tickSpacing)
 
-		rangeLiquidityArray := BuildRangeLiquidityArray(poolState.TickStates)
+		baseLiquidity := poolState.BaseLiquidity
+		if baseLiquidity == nil {
+			baseLiquidity = big.NewInt(0)
+		}
+		rangeLiquidityArray := BuildRangeLiquidityArrayFrom(baseLiquidity, poolState.TickStates)
 		rangeLiquidityArray = FilterRangeLiquidityArray(rangeLiquidityArray, fromTick, toTick)
 		if params.Type == ParamTypeTokenAmountDetail {
 			rangeLiquidityArray = SplitRangeLiquidityArray(rangeLiquidityArray, tickSpacing)
@@ -151,18 +246,290 @@ func (a *apiServer) HandlerPoolState(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const (
+	ParamFrom   = "from"
+	ParamTo     = "to"
+	ParamFromAt = "from_at" // optional, RFC3339 or unix seconds, exclusive with from
+	ParamToAt   = "to_at"   // optional, RFC3339 or unix seconds, exclusive with to
+	ParamCursor = "cursor"
+	ParamLimit  = "limit"
+
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+func parseUintParam(r *http.Request, param string, defaultValue uint64) (uint64, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", param, err)
+	}
+	return n, nil
+}
+
+// parseTimeParam returns nil when the param is missing, it is exclusive with the height param
+func parseTimeParam(r *http.Request, param string, heightParam string) (*uint64, error) {
+	query := r.URL.Query()
+	if !query.Has(param) {
+		return nil, nil
+	}
+	if query.Has(heightParam) {
+		return nil, fmt.Errorf("%s and %s are exclusive", heightParam, param)
+	}
+
+	t, err := parseTime(query.Get(param))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// EventQueryFromHttpRequest resolves from_at and to_at with heightAt: from_at to
+// the first block at or after the time, to_at to the last block at or before it.
+// A to_at after the last processed block reaches to the latest events.
+func EventQueryFromHttpRequest(r *http.Request, heightAt func(timestamp uint64) (uint64, error)) (*EventQuery, error) {
+	kv, err := parseParams(r, []string{ParamAddress})
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := parseUintParam(r, ParamFrom, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := parseUintParam(r, ParamTo, math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+
+	fromAt, err := parseTimeParam(r, ParamFromAt, ParamFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	toAt, err := parseTimeParam(r, ParamToAt, ParamTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if from > to {
+		return nil, errors.New("from is greater than to")
+	}
+	if fromAt != nil && toAt != nil && *fromAt > *toAt {
+		return nil, errors.New("from_at is greater than to_at")
+	}
+
+	// times between two blocks resolve to an empty range, from = to+1
+	if fromAt != nil && *fromAt > 0 {
+		// the block after the last one before from_at
+		height, err := heightAt(*fromAt - 1)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s err: %w", ParamFromAt, err)
+		}
+		from = height + 1
+	}
+
+	if toAt != nil {
+		height, err := heightAt(*toAt)
+		if err != nil && !errors.Is(err, ErrHeightNotIngested) {
+			return nil, fmt.Errorf("resolve %s err: %w", ParamToAt, err)
+		}
+		if err == nil {
+			to = height
+		}
+	}
+
+	limit, err := parseUintParam(r, ParamLimit, defaultEventLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit == 0 || limit > maxEventLimit {
+		limit = maxEventLimit
+	}
+
+	eventType := r.URL.Query().Get(ParamType)
+	if eventType != "" {
+		known := false
+		for _, name := range EventTypeNames {
+			if name == eventType {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event type: %s", eventType)
+		}
+	}
+
+	return &EventQuery{
+		Address:    common.HexToAddress(kv[ParamAddress]),
+		FromHeight: from,
+		ToHeight:   to,
+		Type:       eventType,
+		Cursor:     r.URL.Query().Get(ParamCursor),
+		Limit:      int(limit),
+	}, nil
+}
+
+func (a *apiServer) HandlerEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := EventQueryFromHttpRequest(r, a.poolStateGetter.GetHeightAt)
+	if errors.Is(err, ErrTimeNotIndexed) || errors.Is(err, ErrHeightNotIngested) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("resolve time error: %v", err)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("wrong request params: %v", err)))
+		return
+	}
+
+	page, err := a.db.GetEvents(query)
+	if err != nil {
+		if errors.Is(err, ErrWrongCursor) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(fmt.Sprintf("get events error: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(page)
+	w.Write(jsonData)
+}
+
+const (
+	ParamSort = "sort"
+
+	defaultPoolLimit = 100
+	maxPoolLimit     = 1000
+)
+
+func PoolQueryFromHttpRequest(r *http.Request) (*PoolQuery, error) {
+	limit, err := parseUintParam(r, ParamLimit, defaultPoolLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit == 0 || limit > maxPoolLimit {
+		limit = maxPoolLimit
+	}
+
+	sortBy := r.URL.Query().Get(ParamSort)
+	if sortBy == "" {
+		sortBy = PoolSortAddress
+	}
+	if sortBy != PoolSortAddress && sortBy != PoolSortActivity && sortBy != PoolSortTicks {
+		return nil, fmt.Errorf("unknown sort: %s", sortBy)
+	}
+
+	return &PoolQuery{
+		Sort:   sortBy,
+		Cursor: r.URL.Query().Get(ParamCursor),
+		Limit:  int(limit),
+	}, nil
+}
+
+func (a *apiServer) HandlerPools(w http.ResponseWriter, r *http.Request) {
+	query, err := PoolQueryFromHttpRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("wrong request params: %v", err)))
+		return
+	}
+
+	page, err := a.db.ListPools(query)
+	if err != nil {
+		if errors.Is(err, ErrWrongCursor) || errors.Is(err, ErrWrongPoolSort) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(fmt.Sprintf("list pools error: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(page)
+	w.Write(jsonData)
+}
+
+// HandlerCheckpoint exports a checkpoint of the live db, it is only served on the admin listener
+func (a *apiServer) HandlerCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive, manifest, err := a.checkpointer.Export()
+	if err != nil {
+		if errors.Is(err, ErrCheckpointBusy) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(fmt.Sprintf("export checkpoint error: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(struct {
+		Archive  string              `json:"archive"`
+		Manifest *CheckpointManifest `json:"manifest"`
+	}{archive, manifest})
+	w.Write(jsonData)
+}
+
+// routes are the query routes of the public listener
+func (a *apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pool_state", a.HandlerPoolState)
+	mux.HandleFunc("/events", a.HandlerEvents)
+	mux.HandleFunc("/pools", a.HandlerPools)
+	return mux
+}
+
+// adminRoutes are the operator routes, kept off the public listener
+func (a *apiServer) adminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	if a.checkpointer != nil {
+		mux.HandleFunc("/admin/checkpoint", a.HandlerCheckpoint)
+	}
+	return mux
+}
+
 func (a *apiServer) Start() {
 	go func() {
-		http.HandleFunc("/pool_state", a.HandlerPoolState)
-		err := http.ListenAndServe(":29292", nil)
+		err := http.ListenAndServe(a.addr, a.routes())
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	if a.adminAddr == "" || a.checkpointer == nil {
+		return
+	}
+	go func() {
+		err := http.ListenAndServe(a.adminAddr, a.adminRoutes())
 		if err != nil {
 			panic(err)
 		}
 	}()
 }
 
-func NewAPIServer(poolStateGetter PoolStateGetter) APIServer {
+func NewAPIServer(addr string, adminAddr string, poolStateGetter PoolStateGetter, db DB, checkpointer *Checkpointer) APIServer {
 	return &apiServer{
+		addr:            addr,
+		adminAddr:       adminAddr,
 		poolStateGetter: poolStateGetter,
+		db:              db,
+		checkpointer:    checkpointer,
 	}
 }
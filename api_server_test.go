@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHttpRequest_At(t *testing.T) {
+	const base = "/pool_state?address=0x172fcD41E0913e95784454622d1c3724f546f849&tick_offset=100&type=1&format=json"
+
+	params, err := FromHttpRequest(httptest.NewRequest("GET", base, nil))
+	require.NoError(t, err)
+	require.Nil(t, params.At)
+	require.Zero(t, params.Height)
+
+	// at=0 is a time, not a missing parameter
+	params, err = FromHttpRequest(httptest.NewRequest("GET", base+"&at=0", nil))
+	require.NoError(t, err)
+	require.NotNil(t, params.At)
+	require.Equal(t, uint64(0), *params.At)
+
+	params, err = FromHttpRequest(httptest.NewRequest("GET", base+"&at=2025-06-01T14:00:00Z", nil))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1748786400), *params.At)
+
+	params, err = FromHttpRequest(httptest.NewRequest("GET", base+"&height=45000000", nil))
+	require.NoError(t, err)
+	require.Nil(t, params.At)
+	require.Equal(t, uint64(45000000), params.Height)
+
+	for _, query := range []string{"&at=", "&at=yesterday", "&height=0&at=0", "&height=45000000&at=1748786400"} {
+		_, err = FromHttpRequest(httptest.NewRequest("GET", base+query, nil))
+		require.Error(t, err, query)
+	}
+}
+
+func TestEventQueryFromHttpRequest_At(t *testing.T) {
+	const base = "/events?address=0x172fcD41E0913e95784454622d1c3724f546f849"
+
+	// blocks 100, 101 and 102 at 1000, 1012 and 1024
+	heightAt := func(timestamp uint64) (uint64, error) {
+		switch {
+		case timestamp < 1000:
+			return 0, ErrTimeNotIndexed
+		case timestamp > 1024:
+			return 0, ErrHeightNotIngested
+		}
+		return 100 + (timestamp-1000)/12, nil
+	}
+
+	for query, want := range map[string][2]uint64{
+		"":                              {0, math.MaxUint64},
+		"&from_at=0":                    {0, math.MaxUint64},
+		"&from_at=1012":                 {101, math.MaxUint64},
+		"&from_at=1013":                 {102, math.MaxUint64},
+		"&to_at=1012":                   {0, 101},
+		"&to_at=2000":                   {0, math.MaxUint64},
+		"&from_at=1012&to_at=1023":      {101, 101},
+		"&from_at=1001&to_at=1011":      {101, 100},
+		"&from=5&to_at=1000":            {5, 100},
+		"&from_at=1970-01-01T00:16:52Z": {101, math.MaxUint64},
+	} {
+		q, err := EventQueryFromHttpRequest(httptest.NewRequest("GET", base+query, nil), heightAt)
+		require.NoError(t, err, query)
+		require.Equal(t, want, [2]uint64{q.FromHeight, q.ToHeight}, query)
+	}
+
+	_, err := EventQueryFromHttpRequest(httptest.NewRequest("GET", base+"&from_at=999", nil), heightAt)
+	require.ErrorIs(t, err, ErrTimeNotIndexed)
+	_, err = EventQueryFromHttpRequest(httptest.NewRequest("GET", base+"&from_at=2000", nil), heightAt)
+	require.ErrorIs(t, err, ErrHeightNotIngested)
+
+	for _, query := range []string{"&from_at=", "&from_at=yesterday", "&from=0&from_at=1000", "&to=200&to_at=1000", "&from_at=1024&to_at=1000"} {
+		_, err = EventQueryFromHttpRequest(httptest.NewRequest("GET", base+query, nil), heightAt)
+		require.Error(t, err, query)
+		require.NotErrorIs(t, err, ErrTimeNotIndexed, query)
+	}
+}
+
+func TestAPIServer_AdminRoutes(t *testing.T) {
+	a := &apiServer{checkpointer: &Checkpointer{}}
+
+	// the checkpoint export is not served on the query listener
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/checkpoint", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	a.adminRoutes().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/checkpoint", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	a.adminRoutes().ServeHTTP(rec, httptest.NewRequest("GET", "/pools", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,176 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/grocksdb"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoBackup      = errors.New("no backup found")
+	ErrRestoreTarget = errors.New("restore path is not empty, use -force to overwrite")
+	ErrBackupClosed  = errors.New("backup engine closed")
+)
+
+// BackupScheduler writes incremental backups of the live db with the RocksDB
+// backup engine, keeps the last conf.Keep and verifies each new one. It is
+// closed with the db, before the db itself.
+type BackupScheduler struct {
+	db       *RocksDB
+	conf     *BackupConf
+	engine   *grocksdb.BackupEngine
+	interval time.Duration
+
+	// mu is held for a backup, Close waits for the running one
+	mu     sync.Mutex
+	closed bool
+
+	// Close cancels the scheduler and waits for it before closing the engine
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+func NewBackupScheduler(db *RocksDB, conf *BackupConf) (*BackupScheduler, error) {
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	engine, err := grocksdb.CreateBackupEngineWithPath(db.db, conf.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("open backup engine err: %w", err)
+	}
+
+	s := &BackupScheduler{
+		db:       db,
+		conf:     conf,
+		engine:   engine,
+		interval: time.Duration(conf.IntervalSeconds) * time.Second,
+		cancel:   func() {},
+	}
+	db.BeforeClose(s.Close)
+	return s, nil
+}
+
+// Start backs up every conf.IntervalSeconds until ctx is done or the scheduler is closed
+func (s *BackupScheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.Backup(); err != nil {
+					Log.Error("backup failed", zap.String("dir", s.conf.Dir), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Backup creates a backup, purges the ones beyond conf.Keep and verifies the new one
+func (s *BackupScheduler) Backup() (*grocksdb.BackupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrBackupClosed
+	}
+
+	start := time.Now()
+	if err := s.engine.CreateNewBackupFlush(true); err != nil {
+		return nil, fmt.Errorf("create backup err: %w", err)
+	}
+
+	if s.conf.Keep > 0 {
+		if err := s.engine.PurgeOldBackups(uint32(s.conf.Keep)); err != nil {
+			return nil, fmt.Errorf("purge backups err: %w", err)
+		}
+	}
+
+	infos := s.engine.GetInfo()
+	if len(infos) == 0 {
+		return nil, ErrNoBackup
+	}
+	latest := infos[len(infos)-1]
+
+	if s.conf.Verify {
+		if err := s.engine.VerifyBackup(latest.ID); err != nil {
+			return nil, fmt.Errorf("verify backup %d err: %w", latest.ID, err)
+		}
+	}
+
+	Log.Info("backup created", zap.Uint32("id", latest.ID), zap.Uint64("size", latest.Size), zap.Int("backups", len(infos)), zap.Duration("elapsed", time.Since(start)))
+	return &latest, nil
+}
+
+// Close stops the scheduler, waits for a running backup and closes the engine
+func (s *BackupScheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.engine.Close()
+	}
+}
+
+// RestoreBackup verifies backup id (0 for the latest) in dir and restores it to
+// dbPath. A non-empty dbPath is refused unless force, which deletes it first.
+func RestoreBackup(dir string, dbPath string, id uint32, force bool) error {
+	empty, err := isEmptyDir(dbPath)
+	if err != nil {
+		return err
+	}
+	if !empty && !force {
+		return fmt.Errorf("%w: %s", ErrRestoreTarget, dbPath)
+	}
+
+	engine, err := grocksdb.OpenBackupEngine(grocksdb.NewDefaultOptions(), dir)
+	if err != nil {
+		return fmt.Errorf("open backup engine err: %w", err)
+	}
+	defer engine.Close()
+
+	infos := engine.GetInfo()
+	if len(infos) == 0 {
+		return fmt.Errorf("%w in %s", ErrNoBackup, dir)
+	}
+	if id == 0 {
+		id = infos[len(infos)-1].ID
+	}
+
+	if err = engine.VerifyBackup(id); err != nil {
+		return fmt.Errorf("verify backup %d err: %w", id, err)
+	}
+
+	if !empty {
+		Log.Warn("removing db before restore", zap.String("db", dbPath))
+		if err = os.RemoveAll(dbPath); err != nil {
+			return err
+		}
+	}
+
+	restoreOpts := grocksdb.NewRestoreOptions()
+	defer restoreOpts.Destroy()
+	if err = engine.RestoreDBFromBackup(dbPath, dbPath, restoreOpts, id); err != nil {
+		return fmt.Errorf("restore backup %d err: %w", id, err)
+	}
+
+	Log.Info("backup restored", zap.Uint32("id", id), zap.String("db", dbPath))
+	return nil
+}
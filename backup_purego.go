@@ -0,0 +1,21 @@
+//go:build purego
+
+package main
+
+import (
+	"context"
+)
+
+type BackupScheduler struct{}
+
+func NewBackupScheduler(db *RocksDB, conf *BackupConf) (*BackupScheduler, error) {
+	return nil, ErrNoRocksDB
+}
+
+func (s *BackupScheduler) Start(ctx context.Context) {}
+
+func (s *BackupScheduler) Close() {}
+
+func RestoreBackup(dir string, dbPath string, id uint32, force bool) error {
+	return ErrNoRocksDB
+}
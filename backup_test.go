@@ -0,0 +1,85 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup_RetentionRestore(t *testing.T) {
+	rocksDB := newTestRocksDB(t)
+	db := NewDB(rocksDB)
+	defer db.Close()
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	backups, err := NewBackupScheduler(rocksDB, &BackupConf{Dir: dir, Keep: 2, Verify: true})
+	require.NoError(t, err)
+	defer backups.Close()
+
+	for height := uint64(1); height <= 3; height++ {
+		require.NoError(t, db.SetFinishHeight(height))
+		_, err = backups.Backup()
+		require.NoError(t, err)
+	}
+	require.Len(t, backups.engine.GetInfo(), 2)
+
+	// the db path is not empty
+	err = RestoreBackup(dir, rocksDB.db.Name(), 0, false)
+	require.True(t, errors.Is(err, ErrRestoreTarget), err)
+
+	restored := filepath.Join(t.TempDir(), "db")
+	infos := backups.engine.GetInfo()
+	require.NoError(t, RestoreBackup(dir, restored, infos[0].ID, false))
+	require.Equal(t, uint64(2), finishHeightOf(t, restored))
+
+	require.NoError(t, RestoreBackup(dir, restored, 0, true))
+	require.Equal(t, uint64(3), finishHeightOf(t, restored))
+}
+
+func TestBackupScheduler_CloseDuringBackup(t *testing.T) {
+	rocksDB := newTestRocksDB(t)
+	require.NoError(t, NewDB(rocksDB).SetFinishHeight(1))
+
+	backups, err := NewBackupScheduler(rocksDB, &BackupConf{Dir: filepath.Join(t.TempDir(), "backup"), IntervalSeconds: 1})
+	require.NoError(t, err)
+	backups.interval = time.Millisecond
+
+	// the scheduled backup waits on mu as if another one was running
+	backups.mu.Lock()
+	backups.Start(context.Background())
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		rocksDB.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("the db was closed during a backup")
+	case <-time.After(20 * time.Millisecond):
+	}
+	backups.mu.Unlock()
+	<-closed
+
+	require.NotEmpty(t, backups.engine.GetInfo(), "the running backup completed")
+	_, err = backups.Backup()
+	require.ErrorIs(t, err, ErrBackupClosed)
+}
+
+func finishHeightOf(t *testing.T, path string) uint64 {
+	rocksDB, err := OpenRocksDBReadOnly(path)
+	require.NoError(t, err)
+	defer rocksDB.Close()
+
+	height, err := NewDB(rocksDB).GetFinishHeight()
+	require.NoError(t, err)
+	return height
+}
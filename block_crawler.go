@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"math/big"
 	"sync"
 
-	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/panjf2000/ants/v2"
@@ -20,6 +21,8 @@ type BlockCrawlerWorker interface {
 type blockCrawler struct {
 	inputQueue           chan uint64
 	ethClient            *ethclient.Client
+	retryPolicy          *RetryPolicy
+	rateLimiter          *RateLimiter
 	pool                 *ants.Pool
 	outputSequencer      Sequencer[*BlockReceipt]
 	outputBuffer         chan *BlockReceipt
@@ -52,6 +55,8 @@ func NewBlockCrawler(url string, poolSize int, sequencer Sequencer[*BlockReceipt
 	return &blockCrawler{
 		inputQueue:      make(chan uint64, 1),
 		ethClient:       ethClient,
+		retryPolicy:     GetRetryPolicy(url),
+		rateLimiter:     GetRateLimiter(url),
 		pool:            pool,
 		outputSequencer: sequencer,
 		outputBuffer:    make(chan *BlockReceipt, 1),
@@ -59,22 +64,52 @@ func NewBlockCrawler(url string, poolSize int, sequencer Sequencer[*BlockReceipt
 }
 
 func (c *blockCrawler) getBlock(ctx context.Context, height uint64) (*BlockReceipt, error) {
-	receipts, err := c.ethClient.BlockReceipts(ctx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(height)))
-
+	receipts, err := RateLimited(ctx, c.rateLimiter, CallTypeReceipts, PriorityHigh, func(ctx context.Context) ([]*types.Receipt, error) {
+		return c.ethClient.BlockReceipts(ctx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(height)))
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	timestamp, hasEvents := receiptsTime(receipts)
+	if timestamp == 0 && hasEvents {
+		header, err := RateLimited(ctx, c.rateLimiter, CallTypeHeader, PriorityHigh, func(ctx context.Context) (*types.Header, error) {
+			return c.ethClient.HeaderByNumber(ctx, new(big.Int).SetUint64(height))
+		})
+		if err != nil {
+			return nil, err
+		}
+		timestamp = header.Time
+	}
+
 	return &BlockReceipt{
-		Height:   height,
-		Receipts: receipts,
+		Height:    height,
+		Timestamp: timestamp,
+		Receipts:  receipts,
 	}, nil
 }
 
+// receiptsTime returns the block time carried by the logs, 0 when the node leaves it
+// out, and whether the block has pool events. Only blocks with pool events change a
+// pool state, the others are not worth a header call to index their time.
+func receiptsTime(receipts []*types.Receipt) (timestamp uint64, hasEvents bool) {
+	for _, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			if log.BlockTimestamp != 0 {
+				timestamp = log.BlockTimestamp
+			}
+			if len(log.Topics) > 0 && InputParserBook[log.Topics[0]] != nil {
+				hasEvents = true
+			}
+		}
+	}
+	return timestamp, hasEvents
+}
+
 func (c *blockCrawler) getBlockRetry(ctx context.Context, height uint64) (*BlockReceipt, error) {
-	return retry.DoWithData(func() (*BlockReceipt, error) {
+	return DoWithRetry(ctx, c.retryPolicy, 0, func(ctx context.Context) (*BlockReceipt, error) {
 		return c.getBlock(ctx, height)
-	}, infiniteAttempts, retryDelay)
+	})
 }
 
 func (c *blockCrawler) startCommitOutput() {
@@ -48,8 +48,9 @@ func (p *blockParser) ParseBlock(block *BlockReceipt) *BlockEvent {
 	}
 
 	return &BlockEvent{
-		Height: block.Height,
-		Events: events,
+		Height:    block.Height,
+		Timestamp: block.Timestamp,
+		Events:    events,
 	}
 }
 
@@ -75,16 +76,27 @@ var (
 )
 
 func ParseLog(log *types.Log) (*Event, error) {
+	var event *Event
+	var err error
 	switch log.Topics[0] {
 	case abi_instance.MintTopic0:
-		return ParseMint(log)
+		event, err = ParseMint(log)
 	case abi_instance.BurnTopic0:
-		return ParseBurn(log)
+		event, err = ParseBurn(log)
 	case abi_instance.SwapTopic0:
-		return ParseSwap(log)
+		event, err = ParseSwap(log)
+	case abi_instance.InitializeTopic0:
+		event, err = ParseInitialize(log)
 	default:
 		return nil, ErrUnknownLogTopic
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	event.TxHash = log.TxHash
+	event.LogIndex = log.Index
+	return event, nil
 }
 
 func ParseMint(log *types.Log) (*Event, error) {
@@ -96,6 +108,7 @@ func ParseMint(log *types.Log) (*Event, error) {
 	return &Event{
 		Address:   log.Address,
 		Type:      EventTypeMint,
+		Owner:     common.BytesToAddress(log.Topics[1].Bytes()),
 		TickLower: log.Topics[2].Big(),
 		TickUpper: log.Topics[3].Big(),
 		Amount:    input[1].(*big.Int),
@@ -111,6 +124,7 @@ func ParseBurn(log *types.Log) (*Event, error) {
 	return &Event{
 		Address:   log.Address,
 		Type:      EventTypeBurn,
+		Owner:     common.BytesToAddress(log.Topics[1].Bytes()),
 		TickLower: log.Topics[2].Big(),
 		TickUpper: log.Topics[3].Big(),
 		Amount:    input[0].(*big.Int),
@@ -124,9 +138,25 @@ func ParseSwap(log *types.Log) (*Event, error) {
 	}
 
 	return &Event{
-		Address: log.Address,
-		Type:    EventTypeSwap,
-		Tick:    input[4].(*big.Int),
+		Address:      log.Address,
+		Type:         EventTypeSwap,
+		SqrtPriceX96: input[2].(*big.Int),
+		Liquidity:    input[3].(*big.Int),
+		Tick:         input[4].(*big.Int),
+	}, nil
+}
+
+func ParseInitialize(log *types.Log) (*Event, error) {
+	input, err := ParseInput(log)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Address:      log.Address,
+		Type:         EventTypeInitialize,
+		SqrtPriceX96: input[0].(*big.Int),
+		Tick:         input[1].(*big.Int),
 	}, nil
 }
 
@@ -182,10 +212,18 @@ var (
 		ABIEvent:      abi_instance.SwapEvent,
 	}
 
+	InitializeEventInputParser = &EventInputParser{
+		Topic0:        abi_instance.InitializeTopic0,
+		TopicLen:      1,
+		DataUnpackLen: 2,
+		ABIEvent:      abi_instance.InitializeEvent,
+	}
+
 	InputParserBook = map[common.Hash]*EventInputParser{
-		abi_instance.MintTopic0: MintEventInputParser,
-		abi_instance.BurnTopic0: BurnEventInputParser,
-		abi_instance.SwapTopic0: SwapEventInputParser,
+		abi_instance.MintTopic0:       MintEventInputParser,
+		abi_instance.BurnTopic0:       BurnEventInputParser,
+		abi_instance.SwapTopic0:       SwapEventInputParser,
+		abi_instance.InitializeTopic0: InitializeEventInputParser,
 	}
 )
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+
+	"uniswapv3-tick-state/abi_instance"
+)
+
+// newTestLog packs the non-indexed args of the event as the log data
+func newTestLog(t *testing.T, addr common.Address, event *abi.Event, topics []common.Hash, args ...interface{}) *types.Log {
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	require.NoError(t, err)
+	return &types.Log{
+		Address: addr,
+		Topics:  append([]common.Hash{event.ID}, topics...),
+		Data:    data,
+	}
+}
+
+func newTestInitializeLog(t *testing.T, addr common.Address, sqrtPriceX96 *big.Int, tick int64) *types.Log {
+	return newTestLog(t, addr, abi_instance.InitializeEvent, nil, sqrtPriceX96, big.NewInt(tick))
+}
+
+func newTestMintLog(t *testing.T, addr common.Address, tickLower, tickUpper, amount int64) *types.Log {
+	topics := []common.Hash{
+		common.BytesToHash(addr.Bytes()),
+		common.BigToHash(big.NewInt(tickLower)),
+		common.BigToHash(big.NewInt(tickUpper)),
+	}
+	return newTestLog(t, addr, abi_instance.MintEvent, topics, addr, big.NewInt(amount), big.NewInt(1), big.NewInt(1))
+}
+
+func TestParseBlock_Initialize(t *testing.T) {
+	addr := common.HexToAddress("0x1a0000000000000000000000000000000000001a")
+	sqrtPriceX96, _ := new(big.Int).SetString("79228162514264337593543950336", 10)
+
+	initialize := newTestInitializeLog(t, addr, sqrtPriceX96, -5)
+	initialize.Index = 1
+	mint := newTestMintLog(t, addr, 60, 120, 1000)
+	mint.Index = 2
+	malformed := newTestInitializeLog(t, addr, sqrtPriceX96, 7)
+	malformed.Data = malformed.Data[:32]
+	unknown := &types.Log{Address: addr, Topics: []common.Hash{common.HexToHash("0x01")}}
+
+	block := &BlockReceipt{
+		Height:    100,
+		Timestamp: 1700000000,
+		Receipts: []*types.Receipt{
+			{Status: 1, Logs: []*types.Log{initialize, unknown, malformed, mint}},
+			{Status: 0, Logs: []*types.Log{newTestInitializeLog(t, addr, sqrtPriceX96, 9)}},
+		},
+	}
+
+	// the live crawler keeps the Initialize event and drops what it cannot parse
+	blockEvent := (&blockParser{}).ParseBlock(block)
+	require.Equal(t, uint64(100), blockEvent.Height)
+	require.Len(t, blockEvent.Events, 2)
+
+	event := blockEvent.Events[0]
+	require.Equal(t, EventTypeInitialize, event.Type)
+	require.Equal(t, addr, event.Address)
+	require.Equal(t, uint(1), event.LogIndex)
+	require.Equal(t, int64(-5), event.Tick.Int64())
+	require.Equal(t, 0, sqrtPriceX96.Cmp(event.SqrtPriceX96))
+
+	require.Equal(t, EventTypeMint, blockEvent.Events[1].Type)
+	require.Equal(t, int64(60), blockEvent.Events[1].TickLower.Int64())
+	require.Equal(t, int64(1000), blockEvent.Events[1].Amount.Int64())
+
+	_, err := ParseLog(malformed)
+	require.Error(t, err)
+	_, err = ParseLog(unknown)
+	require.ErrorIs(t, err, ErrUnknownLogTopic)
+
+	// reacting to it moves the current tick and resets the in-range liquidity
+	db := newTestRepo(t)
+	defer db.Close()
+	require.NoError(t, db.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(99),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+	}))
+
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, nil, &EventReactorConf{CheckLiquidity: true})
+	require.NoError(t, reactor.ReactBlockEvent(blockEvent))
+
+	poolState, err := db.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, int64(-5), poolState.Global.Tick.Int64())
+	require.Equal(t, map[int32]int64{60: 1000, 120: -1000}, tickStatesToMap(poolState.TickStates))
+
+	liquidity, err := db.GetActiveLiquidity(addr)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), liquidity.Int64())
+}
+
+func TestReceiptsTime(t *testing.T) {
+	addr := common.HexToAddress("0x1c0000000000000000000000000000000000001c")
+	other := &types.Log{Address: addr, Topics: []common.Hash{common.HexToHash("0x01")}}
+	mint := newTestMintLog(t, addr, 60, 120, 1000)
+
+	timestamp, hasEvents := receiptsTime([]*types.Receipt{{Logs: []*types.Log{other}}})
+	require.Zero(t, timestamp)
+	require.False(t, hasEvents, "no header call for blocks without pool events")
+
+	timestamp, hasEvents = receiptsTime([]*types.Receipt{{Logs: []*types.Log{other}}, {Logs: []*types.Log{mint}}})
+	require.Zero(t, timestamp)
+	require.True(t, hasEvents)
+
+	// nodes that put the block time in the logs save the header call
+	mint.BlockTimestamp = 1700000000
+	timestamp, hasEvents = receiptsTime([]*types.Receipt{{Logs: []*types.Log{mint}}})
+	require.Equal(t, uint64(1700000000), timestamp)
+	require.True(t, hasEvents)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// 9: | height -> block timestamp, written for the processed blocks with pool events, the
+// other blocks change no pool state. Nodes putting the time in the logs index every block with logs.
+
+var (
+	ErrTimeNotIndexed = errors.New("time is before the first indexed block")
+)
+
+func makeBlockTimeKey(height uint64) [10]byte {
+	var key [10]byte
+	copy(key[:2], KeyPrefixBlockTime)
+	copy(key[2:10], uint64ToBytes(height))
+	return key
+}
+
+func (r *rocksDBWrap) SetBlockTime(height uint64, timestamp uint64) error {
+	key := makeBlockTimeKey(height)
+	return r.db.Set(key[:], uint64ToBytes(timestamp))
+}
+
+// GetBlockTime returns 0 if the block is not indexed
+func (r *rocksDBWrap) GetBlockTime(height uint64) (uint64, error) {
+	key := makeBlockTimeKey(height)
+	bytes, err := r.db.Get(key[:])
+	if err != nil {
+		return 0, err
+	}
+
+	if bytes == nil {
+		return 0, nil
+	}
+
+	return bytesToUint64(bytes), nil
+}
+
+// indexedBlockAtOrBefore returns the last indexed block not above height, ok is false if there is none
+func (r *rocksDBWrap) indexedBlockAtOrBefore(height uint64) (h uint64, timestamp uint64, ok bool, err error) {
+	from := makeBlockTimeKey(0)
+	to := makeBlockTimeKey(height)
+	entry, err := r.db.GetPrev(from[:], to[:])
+	if err != nil || entry == nil {
+		return 0, 0, false, err
+	}
+
+	return bytesToUint64(entry.K()[2:10]), bytesToUint64(entry.V()), true, nil
+}
+
+// GetHeightAt returns the last processed block with time <= timestamp, by binary search over the index
+func (r *rocksDBWrap) GetHeightAt(timestamp uint64) (uint64, error) {
+	v, release := r.view()
+	defer release()
+	return v.getHeightAt(timestamp)
+}
+
+func (r *rocksDBWrap) getHeightAt(timestamp uint64) (uint64, error) {
+	finishHeight, err := r.GetFinishHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	lastHeight, lastTime, ok, err := r.indexedBlockAtOrBefore(finishHeight)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, ErrTimeNotIndexed
+	}
+
+	// a later block, not processed yet, may still be before timestamp
+	if timestamp > lastTime {
+		return 0, ErrHeightNotIngested
+	}
+
+	from := makeBlockTimeKey(0)
+	to := makeBlockTimeKey(math.MaxUint64)
+	first, err := r.db.GetNext(from[:], to[:])
+	if err != nil {
+		return 0, err
+	}
+
+	lo := bytesToUint64(first.K()[2:10])
+	if timestamp < bytesToUint64(first.V()) {
+		return 0, ErrTimeNotIndexed
+	}
+
+	// the time of the last indexed block at or before h never decreases with h,
+	// find the greatest h in [lo, lastHeight] whose time is <= timestamp
+	hi := lastHeight
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		_, t, _, err := r.indexedBlockAtOrBefore(mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if t <= timestamp {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	height, _, _, err := r.indexedBlockAtOrBefore(lo)
+	return height, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_GetHeightAt(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	// block 13 is missing from the index, blocks 15 and 16 share a timestamp
+	blockTimes := map[uint64]uint64{10: 1000, 11: 1003, 12: 1006, 14: 1012, 15: 1015, 16: 1015, 17: 1018}
+	for height, timestamp := range blockTimes {
+		if err := repo.SetBlockTime(height, timestamp); err != nil {
+			t.Fatalf("SetBlockTime failed: %v", err)
+		}
+	}
+	if err := repo.SetFinishHeight(17); err != nil {
+		t.Fatalf("SetFinishHeight failed: %v", err)
+	}
+
+	tests := []struct {
+		timestamp uint64
+		height    uint64
+		err       error
+	}{
+		{999, 0, ErrTimeNotIndexed},
+		{1000, 10, nil},
+		{1002, 10, nil},
+		{1003, 11, nil},
+		{1011, 12, nil},
+		{1014, 14, nil},
+		{1015, 16, nil},
+		{1018, 17, nil},
+		{1019, 0, ErrHeightNotIngested},
+	}
+	for _, test := range tests {
+		height, err := repo.GetHeightAt(test.timestamp)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("GetHeightAt(%d): want err %v, got %v", test.timestamp, test.err, err)
+		}
+		if height != test.height {
+			t.Fatalf("GetHeightAt(%d): want %d, got %d", test.timestamp, test.height, height)
+		}
+	}
+
+	timestamp, err := repo.GetBlockTime(13)
+	if err != nil || timestamp != 0 {
+		t.Fatalf("GetBlockTime(13): want 0, got %d, %v", timestamp, err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BlockWrite collects the changes of one block over the committed state. It is
+// written by DB.WriteBlock in a single batch together with the finish height,
+// so a snapshot never sees half a block.
+type BlockWrite struct {
+	db        DB
+	Height    uint64
+	Timestamp uint64
+
+	TickStates      map[common.Address]map[int32]*TickState
+	CurrentTicks    map[common.Address]int32
+	ActiveLiquidity map[common.Address]*big.Int
+	Events          map[common.Address][]*EventRecord
+	EventCounts     map[common.Address]map[string]uint64
+	Deleted         map[common.Address]struct{}
+}
+
+func NewBlockWrite(db DB, height uint64, timestamp uint64) *BlockWrite {
+	return &BlockWrite{
+		db:              db,
+		Height:          height,
+		Timestamp:       timestamp,
+		TickStates:      make(map[common.Address]map[int32]*TickState),
+		CurrentTicks:    make(map[common.Address]int32),
+		ActiveLiquidity: make(map[common.Address]*big.Int),
+		Events:          make(map[common.Address][]*EventRecord),
+		EventCounts:     make(map[common.Address]map[string]uint64),
+		Deleted:         make(map[common.Address]struct{}),
+	}
+}
+
+func (w *BlockWrite) GetTickState(addr common.Address, tick int32) (*TickState, error) {
+	if tickState, ok := w.TickStates[addr][tick]; ok {
+		return tickState, nil
+	}
+	return w.db.GetTickState(addr, tick)
+}
+
+func (w *BlockWrite) SetTickState(addr common.Address, tickState *TickState) error {
+	tickStates, ok := w.TickStates[addr]
+	if !ok {
+		tickStates = make(map[int32]*TickState)
+		w.TickStates[addr] = tickStates
+	}
+	tickStates[tickState.Tick] = tickState
+	return nil
+}
+
+// GetTickStates is the committed tick map of the pool with the pending ticks applied
+func (w *BlockWrite) GetTickStates(addr common.Address) ([]*TickState, error) {
+	tickStates, err := w.db.GetTickStates(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := w.TickStates[addr]
+	if len(pending) == 0 {
+		return tickStates, nil
+	}
+
+	merged := make([]*TickState, 0, len(tickStates)+len(pending))
+	for _, ts := range tickStates {
+		if _, ok := pending[ts.Tick]; !ok {
+			merged = append(merged, ts)
+		}
+	}
+	for _, ts := range pending {
+		merged = append(merged, ts)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Tick < merged[j].Tick })
+	return merged, nil
+}
+
+func (w *BlockWrite) GetCurrentTick(addr common.Address) (int32, error) {
+	if tick, ok := w.CurrentTicks[addr]; ok {
+		return tick, nil
+	}
+	return w.db.GetCurrentTick(addr)
+}
+
+func (w *BlockWrite) SetCurrentTick(addr common.Address, tick int32) error {
+	w.CurrentTicks[addr] = tick
+	return nil
+}
+
+func (w *BlockWrite) GetActiveLiquidity(addr common.Address) (*big.Int, error) {
+	if liquidity, ok := w.ActiveLiquidity[addr]; ok {
+		return new(big.Int).Set(liquidity), nil
+	}
+	return w.db.GetActiveLiquidity(addr)
+}
+
+func (w *BlockWrite) SetActiveLiquidity(addr common.Address, liquidity *big.Int) error {
+	w.ActiveLiquidity[addr] = new(big.Int).Set(liquidity)
+	return nil
+}
+
+func (w *BlockWrite) AddEvent(addr common.Address, record *EventRecord) {
+	w.Events[addr] = append(w.Events[addr], record)
+}
+
+// CountEvent counts an applied event in the pool index, stored or not
+func (w *BlockWrite) CountEvent(addr common.Address, eventType string) {
+	counts, ok := w.EventCounts[addr]
+	if !ok {
+		counts = make(map[string]uint64)
+		w.EventCounts[addr] = counts
+	}
+	counts[eventType]++
+}
+
+// Drop discards the pending changes of the pool
+func (w *BlockWrite) Drop(addr common.Address) {
+	delete(w.TickStates, addr)
+	delete(w.CurrentTicks, addr)
+	delete(w.ActiveLiquidity, addr)
+	delete(w.Events, addr)
+	delete(w.EventCounts, addr)
+}
+
+// Delete drops the pending changes of the pool and deletes its state together with the block
+func (w *BlockWrite) Delete(addr common.Address) {
+	w.Drop(addr)
+	w.Deleted[addr] = struct{}{}
+}
+
+// IsDeleted reports whether the pool state is deleted with the block
+func (w *BlockWrite) IsDeleted(addr common.Address) bool {
+	_, ok := w.Deleted[addr]
+	return ok
+}
+
+// Touched returns the changed and the deleted pools in address order
+func (w *BlockWrite) Touched() []common.Address {
+	addrs := w.Addresses()
+	for addr := range w.Deleted {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
+// sumLiquidityNetBetween is the sum of liquidityNet of the ticks in [fromTick, toTick], pending ticks applied
+func (w *BlockWrite) sumLiquidityNetBetween(addr common.Address, fromTick, toTick int32) (*big.Int, error) {
+	pending := w.TickStates[addr]
+	sum := big.NewInt(0)
+	err := w.db.ScanTickStates(addr, fromTick, toTick, func(tickState *TickState) error {
+		if _, ok := pending[tickState.Tick]; !ok {
+			sum.Add(sum, tickState.LiquidityNet)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for tick, ts := range pending {
+		if tick >= fromTick && tick <= toTick {
+			sum.Add(sum, ts.LiquidityNet)
+		}
+	}
+	return sum, nil
+}
+
+// Addresses returns the pools changed in the block in address order, their height moves to the block height
+func (w *BlockWrite) Addresses() []common.Address {
+	seen := make(map[common.Address]struct{})
+	for addr := range w.TickStates {
+		seen[addr] = struct{}{}
+	}
+	for addr := range w.CurrentTicks {
+		seen[addr] = struct{}{}
+	}
+	for addr := range w.ActiveLiquidity {
+		seen[addr] = struct{}{}
+	}
+	for addr := range w.Events {
+		seen[addr] = struct{}{}
+	}
+	for addr := range w.EventCounts {
+		seen[addr] = struct{}{}
+	}
+
+	addrs := make([]common.Address, 0, len(seen))
+	for addr := range seen {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
+// WriteBlock writes the block changes, their history versions, the pool index,
+// the deleted pools, the block time and the finish height in one batch. The
+// history of a deleted pool is kept up to the block.
+func (r *rocksDBWrap) WriteBlock(w *BlockWrite) error {
+	batch := r.db.NewBatch()
+	defer batch.Destroy()
+
+	if err := r.indexBlock(w, batch); err != nil {
+		return err
+	}
+
+	for addr := range w.Deleted {
+		if err := r.deletePoolStateInBatch(addr, w.Height, 0, batch); err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range w.Addresses() {
+		heightKey := makePoolHeightKey(addr)
+		batch.Put(heightKey[:], uint64ToBytes(w.Height))
+	}
+
+	for addr, tickStates := range w.TickStates {
+		for _, ts := range tickStates {
+			value, err := ts.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			batch.Put(GetTickStateKey(addr, ts.Tick).GetKey(), value)
+
+			tickHistoryKey := makeTickHistoryKey(addr, ts.Tick, w.Height)
+			batch.Put(tickHistoryKey[:], value)
+		}
+	}
+
+	for addr, tick := range w.CurrentTicks {
+		tickKey := makeCurrentTickKey(addr)
+		batch.Put(tickKey[:], int32ToBytes(tick))
+
+		currentTickHistoryKey := makeCurrentTickHistoryKey(addr, w.Height)
+		batch.Put(currentTickHistoryKey[:], int32ToBytes(tick))
+	}
+
+	for addr, liquidity := range w.ActiveLiquidity {
+		value, err := int128ToBytes(liquidity)
+		if err != nil {
+			return err
+		}
+		activeLiquidityKey := makeActiveLiquidityKey(addr)
+		batch.Put(activeLiquidityKey[:], value)
+	}
+
+	for addr, records := range w.Events {
+		for _, record := range records {
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			eventKey := makeEventKey(addr, record.Height, record.LogIndex)
+			batch.Put(eventKey[:], value)
+		}
+	}
+
+	if w.Timestamp != 0 {
+		blockTimeKey := makeBlockTimeKey(w.Height)
+		batch.Put(blockTimeKey[:], uint64ToBytes(w.Timestamp))
+	}
+
+	batch.Put(HeightKey, uint64ToBytes(w.Height))
+
+	return r.db.WriteBatch(batch)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A checkpoint archive is a tar.gz of a RocksDB checkpoint plus checkpoint.json:
+//
+//	checkpoint.json -> CheckpointManifest
+//	CURRENT, MANIFEST-*, OPTIONS-*, *.sst -> the checkpoint files
+
+const (
+	CheckpointManifestFile = "checkpoint.json"
+)
+
+var (
+	ErrCheckpointChain    = errors.New("checkpoint is of another chain")
+	ErrCheckpointSchema   = errors.New("checkpoint schema version is newer than supported")
+	ErrCheckpointChecksum = errors.New("checkpoint checksum mismatch")
+	ErrCheckpointTarget   = errors.New("db path is not empty")
+	ErrCheckpointBusy     = errors.New("checkpoint in progress")
+)
+
+type CheckpointManifest struct {
+	ChainID       uint64            `json:"chain_id"`
+	SchemaVersion uint32            `json:"schema_version"`
+	Height        uint64            `json:"height"` // finish height in the checkpoint
+	CreatedAt     int64             `json:"created_at"`
+	Files         map[string]string `json:"files"` // file name -> sha256 hex
+}
+
+// Checkpointer exports checkpoints of the live db into Dir, one at a time
+type Checkpointer struct {
+	db      *RocksDB
+	chainID uint64
+	dir     string
+	mu      sync.Mutex
+}
+
+func NewCheckpointer(db *RocksDB, chainID uint64, dir string) *Checkpointer {
+	return &Checkpointer{
+		db:      db,
+		chainID: chainID,
+		dir:     dir,
+	}
+}
+
+// Export writes a checkpoint archive into the checkpoint dir and returns its path
+func (c *Checkpointer) Export() (string, *CheckpointManifest, error) {
+	if !c.mu.TryLock() {
+		return "", nil, ErrCheckpointBusy
+	}
+	defer c.mu.Unlock()
+
+	return ExportCheckpoint(c.db, c.chainID, c.dir)
+}
+
+// ExportCheckpoint takes an online checkpoint of db and packages it as
+// checkpoint-<chain>-<height>.tar.gz in dir
+func ExportCheckpoint(db *RocksDB, chainID uint64, dir string) (string, *CheckpointManifest, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", nil, err
+	}
+
+	tmp := filepath.Join(dir, fmt.Sprintf(".checkpoint-%d", time.Now().UnixNano()))
+	defer os.RemoveAll(tmp)
+
+	if err := db.Checkpoint(tmp); err != nil {
+		return "", nil, fmt.Errorf("create checkpoint err: %w", err)
+	}
+
+	manifest, err := readCheckpointMeta(tmp)
+	if err != nil {
+		return "", nil, err
+	}
+	manifest.ChainID = chainID
+	manifest.CreatedAt = time.Now().Unix()
+
+	if manifest.Files, err = checksumFiles(tmp); err != nil {
+		return "", nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	if err = os.WriteFile(filepath.Join(tmp, CheckpointManifestFile), data, 0o644); err != nil {
+		return "", nil, err
+	}
+
+	archive := filepath.Join(dir, fmt.Sprintf("checkpoint-%d-%d.tar.gz", chainID, manifest.Height))
+	if err = writeArchive(tmp, archive+".tmp"); err != nil {
+		os.Remove(archive + ".tmp")
+		return "", nil, err
+	}
+	if err = os.Rename(archive+".tmp", archive); err != nil {
+		return "", nil, err
+	}
+
+	Log.Info("checkpoint exported", zap.String("archive", archive), zap.Uint64("height", manifest.Height), zap.Uint32("schemaVersion", manifest.SchemaVersion))
+	return archive, manifest, nil
+}
+
+// ImportCheckpoint validates a checkpoint archive and installs it at dbPath,
+// which must be missing or empty. chainID 0 skips the chain check.
+func ImportCheckpoint(archive string, dbPath string, chainID uint64) (*CheckpointManifest, error) {
+	empty, err := isEmptyDir(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, fmt.Errorf("%w: %s", ErrCheckpointTarget, dbPath)
+	}
+
+	tmp := filepath.Clean(dbPath) + ".import"
+	if err = os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err = extractArchive(archive, tmp); err != nil {
+		return nil, fmt.Errorf("extract %s err: %w", archive, err)
+	}
+
+	manifest, err := verifyCheckpoint(tmp, chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Remove(filepath.Join(tmp, CheckpointManifestFile)); err != nil {
+		return nil, err
+	}
+	if err = os.RemoveAll(dbPath); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp, dbPath); err != nil {
+		return nil, err
+	}
+
+	Log.Info("checkpoint imported", zap.String("db", dbPath), zap.Uint64("height", manifest.Height), zap.Uint32("schemaVersion", manifest.SchemaVersion))
+	return manifest, nil
+}
+
+// verifyCheckpoint checks an extracted checkpoint against its manifest
+func verifyCheckpoint(dir string, chainID uint64) (*CheckpointManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &CheckpointManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("decode %s err: %w", CheckpointManifestFile, err)
+	}
+
+	if chainID != 0 && manifest.ChainID != chainID {
+		return nil, fmt.Errorf("%w: checkpoint=%d, node=%d", ErrCheckpointChain, manifest.ChainID, chainID)
+	}
+
+	if manifest.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%w: checkpoint=%d, supported=%d", ErrCheckpointSchema, manifest.SchemaVersion, SchemaVersion)
+	}
+
+	files, err := checksumFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	delete(files, CheckpointManifestFile)
+
+	if len(files) != len(manifest.Files) {
+		return nil, fmt.Errorf("%w: %d files, manifest lists %d", ErrCheckpointChecksum, len(files), len(manifest.Files))
+	}
+	for name, sum := range manifest.Files {
+		if files[name] != sum {
+			return nil, fmt.Errorf("%w: %s", ErrCheckpointChecksum, name)
+		}
+	}
+
+	meta, err := readCheckpointMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Height != manifest.Height || meta.SchemaVersion != manifest.SchemaVersion {
+		return nil, fmt.Errorf("%w: db has height %d, schema version %d", ErrCheckpointChecksum, meta.Height, meta.SchemaVersion)
+	}
+
+	return manifest, nil
+}
+
+// readCheckpointMeta reads the finish height and schema version of a checkpoint
+func readCheckpointMeta(dir string) (*CheckpointManifest, error) {
+	db, err := OpenRocksDBReadOnly(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version, _, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := NewDB(db).GetFinishHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckpointManifest{SchemaVersion: version, Height: height}, nil
+}
+
+func checksumFiles(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		sums[entry.Name()] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+func writeArchive(dir string, archive string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err = addArchiveFile(tw, dir, entry); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func addArchiveFile(tw *tar.Writer, dir string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	src, err := os.Open(filepath.Join(dir, entry.Name()))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+// extractArchive unpacks the flat archive written by writeArchive into dir
+func extractArchive(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg || header.Name != filepath.Base(header.Name) || strings.HasPrefix(header.Name, ".") {
+			return fmt.Errorf("unexpected archive entry %q", header.Name)
+		}
+
+		if err = extractFile(tr, filepath.Join(dir, header.Name)); err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string) error {
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err = io.Copy(dst, r); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
+
+// isEmptyDir is true for a missing path or an empty directory
+func isEmptyDir(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
@@ -0,0 +1,83 @@
+//go:build !purego
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint_ExportImport(t *testing.T) {
+	rocksDB := newTestRocksDB(t)
+	require.NoError(t, MigrateSchema(rocksDB, Migrations, SchemaVersion))
+	db := NewDB(rocksDB)
+	defer db.Close()
+
+	addr := common.HexToAddress("0xc00000000000000000000000000000000000000c")
+	require.NoError(t, db.SetCurrentTick(addr, -42))
+	require.NoError(t, db.SetFinishHeight(1234))
+
+	dir := t.TempDir()
+	archive, manifest, err := ExportCheckpoint(rocksDB, 56, dir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "checkpoint-56-1234.tar.gz"), archive)
+	require.Equal(t, uint64(1234), manifest.Height)
+	require.Equal(t, SchemaVersion, manifest.SchemaVersion)
+	require.NotEmpty(t, manifest.Files)
+
+	_, err = ImportCheckpoint(archive, filepath.Join(t.TempDir(), "db"), 1)
+	require.True(t, errors.Is(err, ErrCheckpointChain), err)
+
+	notEmpty := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(notEmpty, "x"), nil, 0o644))
+	_, err = ImportCheckpoint(archive, notEmpty, 56)
+	require.True(t, errors.Is(err, ErrCheckpointTarget), err)
+
+	dbPath := filepath.Join(t.TempDir(), "db")
+	_, err = ImportCheckpoint(archive, dbPath, 56)
+	require.NoError(t, err)
+
+	imported, err := OpenRocksDBReadOnly(dbPath)
+	require.NoError(t, err)
+	defer imported.Close()
+	importedDB := NewDB(imported)
+
+	height, err := importedDB.GetFinishHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1234), height)
+	tick, err := importedDB.GetCurrentTick(addr)
+	require.NoError(t, err)
+	require.Equal(t, int32(-42), tick)
+}
+
+func TestCheckpoint_Checksum(t *testing.T) {
+	rocksDB := newTestRocksDB(t)
+	defer rocksDB.Close()
+	require.NoError(t, MigrateSchema(rocksDB, Migrations, SchemaVersion))
+
+	dir := t.TempDir()
+	archive, _, err := ExportCheckpoint(rocksDB, 56, dir)
+	require.NoError(t, err)
+
+	// repack with one file changed
+	extracted := filepath.Join(t.TempDir(), "extracted")
+	require.NoError(t, extractArchive(archive, extracted))
+	entries, err := os.ReadDir(extracted)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Name() != CheckpointManifestFile {
+			require.NoError(t, os.WriteFile(filepath.Join(extracted, entry.Name()), []byte("corrupt"), 0o644))
+			break
+		}
+	}
+	tampered := filepath.Join(dir, "tampered.tar.gz")
+	require.NoError(t, writeArchive(extracted, tampered))
+
+	_, err = ImportCheckpoint(tampered, filepath.Join(t.TempDir(), "db"), 56)
+	require.True(t, errors.Is(err, ErrCheckpointChecksum), err)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker open")
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker opens after threshold consecutive failures and rejects calls
+// for cooldown, then lets a single trial call through to decide whether to close again.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     int
+	openedAt  time.Time
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// only the trial call is in flight
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.state = circuitClosed
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == circuitHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		if b.state != circuitOpen {
+			Log.Warn("circuit breaker open", zap.String("name", b.name), zap.Int("failures", b.failures))
+		}
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends a call that says nothing about the endpoint, like a canceled one.
+// A trial call gives way to the next one, the failure count stays.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		// the cooldown has passed, the next Allow starts a new trial
+		b.state = circuitOpen
+	}
+}
@@ -1,39 +1,316 @@
+//go:build !purego
+
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/linxGnu/grocksdb"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+const (
+	FormatJSON = "json" // one object per line
+	FormatCSV  = "csv"
+	FormatHex  = "hex" // cf:key:value, undecoded
 )
 
+// poolKinds are the kinds whose keys start with prefix | pool address
+var poolKinds = map[string][]byte{
+	dbkey.KindTickState:          dbkey.PrefixTickState,
+	dbkey.KindCurrentTick:        dbkey.PrefixCurrentTick,
+	dbkey.KindTickSpacing:        dbkey.PrefixTickSpacing,
+	dbkey.KindPoolHeight:         dbkey.PrefixPoolHeight,
+	dbkey.KindTickHistory:        dbkey.PrefixTickHistory,
+	dbkey.KindCurrentTickHistory: dbkey.PrefixCurrentTickHistory,
+	dbkey.KindEvent:              dbkey.PrefixEvent,
+	dbkey.KindActiveLiquidity:    dbkey.PrefixActiveLiquidity,
+	dbkey.KindPoolInfo:           dbkey.PrefixPoolInfo,
+}
+
+type filter struct {
+	pool     *common.Address
+	prefixes [][]byte // empty for all keys
+	tickFrom int32
+	tickTo   int32
+}
+
+// seeks are the key prefixes to iterate, nil for the whole db
+func (f *filter) seeks() [][]byte {
+	if f.pool == nil {
+		return f.prefixes
+	}
+
+	prefixes := f.prefixes
+	if len(prefixes) == 0 {
+		for _, prefix := range poolKinds {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	var seeks [][]byte
+	for _, prefix := range prefixes {
+		if _, ok := poolKinds[dbkey.KindOf(prefix)]; ok {
+			seeks = append(seeks, append(append([]byte{}, prefix...), f.pool[:]...))
+		}
+	}
+	return seeks
+}
+
+func (f *filter) match(entry *dbkey.Entry) bool {
+	if f.pool != nil && (entry.Pool == nil || *entry.Pool != *f.pool) {
+		return false
+	}
+	if entry.Tick != nil && (*entry.Tick < f.tickFrom || *entry.Tick > f.tickTo) {
+		return false
+	}
+	return true
+}
+
+// parsePrefixes takes a comma separated list of kinds (tick_state) or raw prefixes (2:)
+func parsePrefixes(s string) ([][]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	kinds := map[string][]byte{
+		dbkey.KindSchemaVersion: dbkey.SchemaVersionKey,
+		dbkey.KindMigration:     dbkey.MigrationProgressKey,
+		dbkey.KindFinishHeight:  dbkey.HeightKey,
+		dbkey.KindHistoryFloor:  dbkey.HistoryFloorKey,
+		dbkey.KindBlockTime:     dbkey.PrefixBlockTime,
+	}
+	for kind, prefix := range poolKinds {
+		kinds[kind] = prefix
+	}
+
+	var prefixes [][]byte
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if prefix, ok := kinds[name]; ok {
+			prefixes = append(prefixes, prefix)
+		} else if dbkey.KindOf([]byte(name)) != dbkey.KindUnknown {
+			prefixes = append(prefixes, []byte(name))
+		} else {
+			return nil, fmt.Errorf("unknown prefix %q", name)
+		}
+	}
+	return prefixes, nil
+}
+
 func main() {
 	var dbPath string
 	flag.StringVar(&dbPath, "db", "/tmp/.db", "RocksDB path")
+
+	var format string
+	flag.StringVar(&format, "format", FormatJSON, "output format: json, csv or hex")
+
+	var pool string
+	flag.StringVar(&pool, "pool", "", "only keys of this pool address")
+
+	var prefix string
+	flag.StringVar(&prefix, "prefix", "", "only keys of these comma separated kinds (tick_state) or prefixes (2:)")
+
+	var tickFrom, tickTo int
+	flag.IntVar(&tickFrom, "tick-from", int(dbkey.MinInt24), "only ticks >= tick-from, for keys with a tick")
+	flag.IntVar(&tickTo, "tick-to", int(dbkey.MaxInt24), "only ticks <= tick-to, for keys with a tick")
+
+	var summary bool
+	flag.BoolVar(&summary, "summary", false, "print per-pool statistics instead of the entries")
 	flag.Parse()
 
+	if format != FormatJSON && format != FormatCSV && format != FormatHex {
+		fatalf("unknown format %q", format)
+	}
+	if summary && format == FormatHex {
+		fatalf("-summary needs json or csv")
+	}
+
+	f := &filter{tickFrom: int32(tickFrom), tickTo: int32(tickTo)}
+	if pool != "" {
+		if !common.IsHexAddress(pool) {
+			fatalf("invalid pool address %q", pool)
+		}
+		addr := common.HexToAddress(pool)
+		f.pool = &addr
+	}
+	var err error
+	if f.prefixes, err = parsePrefixes(prefix); err != nil {
+		fatalf("%v", err)
+	}
+
 	opts := grocksdb.NewDefaultOptions()
 	opts.SetCreateIfMissing(false)
-	db, err := grocksdb.OpenDb(opts, dbPath)
+
+	cfNames, err := grocksdb.ListColumnFamilies(opts, dbPath)
+	if err != nil {
+		fatalf("list column families failed: %v", err)
+	}
+
+	cfOpts := make([]*grocksdb.Options, len(cfNames))
+	for i := range cfOpts {
+		cfOpts[i] = opts
+	}
+
+	db, handles, err := grocksdb.OpenDbForReadOnlyColumnFamilies(opts, dbPath, cfNames, cfOpts, false)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "open rocksdb failed: %v\n", err)
-		os.Exit(1)
+		fatalf("open rocksdb failed: %v", err)
 	}
 	defer db.Close()
 
+	seeks := f.seeks()
+	if f.pool != nil && len(seeks) == 0 {
+		fatalf("-prefix selects no pool keys")
+	}
+
+	w := newWriter(os.Stdout, format)
+	summaries := newSummaries()
+
+	err = scan(db, cfNames, handles, seeks, func(cf string, key, value []byte) error {
+		entry := dbkey.Decode(key, value)
+		if !f.match(entry) {
+			return nil
+		}
+
+		if summary {
+			summaries.add(entry)
+			return nil
+		}
+		if format == FormatHex {
+			return w.hex(cf, key, value)
+		}
+		return w.entry(cf, entry)
+	})
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if summary {
+		err = summaries.write(w)
+	}
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// scan calls fn for the keys of every column family under each seek prefix,
+// or for all keys when seeks is empty
+func scan(db *grocksdb.DB, cfNames []string, handles []*grocksdb.ColumnFamilyHandle, seeks [][]byte, fn func(cf string, key, value []byte) error) error {
 	readOpts := grocksdb.NewDefaultReadOptions()
 	defer readOpts.Destroy()
+	readOpts.SetTotalOrderSeek(true)
 
-	it := db.NewIterator(readOpts)
+	if len(seeks) == 0 {
+		seeks = [][]byte{nil}
+	}
+
+	for i, handle := range handles {
+		for _, seek := range seeks {
+			if err := scanCF(db, readOpts, handle, cfNames[i], seek, fn); err != nil {
+				return err
+			}
+		}
+		handle.Destroy()
+	}
+	return nil
+}
+
+func scanCF(db *grocksdb.DB, readOpts *grocksdb.ReadOptions, handle *grocksdb.ColumnFamilyHandle, cf string, seek []byte, fn func(cf string, key, value []byte) error) error {
+	it := db.NewIteratorCF(readOpts, handle)
 	defer it.Close()
-	it.SeekToFirst()
-	for ; it.Valid(); it.Next() {
-		key := it.Key().Data()
-		val := it.Value().Data()
-		fmt.Printf("%s:%s\n", hex.EncodeToString(key), hex.EncodeToString(val))
-		it.Key().Free()
-		it.Value().Free()
+
+	for it.Seek(seek); it.Valid(); it.Next() {
+		key := it.Key()
+		value := it.Value()
+		inRange := bytes.HasPrefix(key.Data(), seek)
+
+		var err error
+		if inRange {
+			err = fn(cf, key.Data(), value.Data())
+		}
+		key.Free()
+		value.Free()
+
+		if err != nil {
+			return err
+		}
+		if !inRange {
+			break
+		}
 	}
+	return it.Err()
+}
+
+type writer struct {
+	format string
+	out    io.Writer
+	json   *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+func newWriter(out io.Writer, format string) *writer {
+	return &writer{format: format, out: out, json: json.NewEncoder(out), csv: csv.NewWriter(out)}
+}
+
+func (w *writer) hex(cf string, key, value []byte) error {
+	_, err := fmt.Fprintf(w.out, "%s:%s:%s\n", cf, hex.EncodeToString(key), hex.EncodeToString(value))
+	return err
+}
+
+func (w *writer) entry(cf string, entry *dbkey.Entry) error {
+	if w.format == FormatJSON {
+		return w.json.Encode(struct {
+			CF string `json:"cf"`
+			*dbkey.Entry
+		}{cf, entry})
+	}
+
+	pool := ""
+	if entry.Pool != nil {
+		pool = entry.Pool.Hex()
+	}
+	return w.row([]string{"cf", "kind", "pool", "tick", "height", "log_index", "key", "value", "error"},
+		[]string{cf, entry.Kind, pool, formatPtr(entry.Tick), formatPtr(entry.Height), formatPtr(entry.LogIndex), entry.Key, entry.Value, entry.Error})
+}
+
+// row writes a csv row, after the header on the first call
+func (w *writer) row(header, record []string) error {
+	if !w.header {
+		w.header = true
+		if err := w.csv.Write(header); err != nil {
+			return err
+		}
+	}
+	return w.csv.Write(record)
+}
+
+func (w *writer) flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func formatPtr[T int32 | uint32 | uint64](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
 }
@@ -0,0 +1,110 @@
+//go:build !purego
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// poolSummary are the statistics of one pool over the dumped entries
+type poolSummary struct {
+	Pool               common.Address `json:"pool"`
+	Ticks              int            `json:"ticks"`
+	MinTick            *int32         `json:"minTick,omitempty"`
+	MaxTick            *int32         `json:"maxTick,omitempty"`
+	LiquidityNetSum    string         `json:"liquidityNetSum"`
+	CurrentTick        string         `json:"currentTick,omitempty"`
+	TickSpacing        string         `json:"tickSpacing,omitempty"`
+	Height             string         `json:"height,omitempty"`
+	ActiveLiquidity    string         `json:"activeLiquidity,omitempty"`
+	TickHistory        int            `json:"tickHistory"`
+	CurrentTickHistory int            `json:"currentTickHistory"`
+	Events             int            `json:"events"`
+	Errors             int            `json:"errors"`
+
+	liquidityNetSum *big.Int
+}
+
+type summaries map[common.Address]*poolSummary
+
+func newSummaries() summaries {
+	return make(summaries)
+}
+
+// add counts a pool-scoped entry, entries of no pool are skipped
+func (s summaries) add(entry *dbkey.Entry) {
+	if entry.Pool == nil {
+		return
+	}
+
+	summary, ok := s[*entry.Pool]
+	if !ok {
+		summary = &poolSummary{Pool: *entry.Pool, liquidityNetSum: new(big.Int)}
+		s[*entry.Pool] = summary
+	}
+
+	if entry.Error != "" {
+		summary.Errors++
+		return
+	}
+
+	switch entry.Kind {
+	case dbkey.KindTickState:
+		summary.Ticks++
+		if summary.MinTick == nil || *entry.Tick < *summary.MinTick {
+			summary.MinTick = entry.Tick
+		}
+		if summary.MaxTick == nil || *entry.Tick > *summary.MaxTick {
+			summary.MaxTick = entry.Tick
+		}
+		liquidityNet, _ := new(big.Int).SetString(entry.Value, 10)
+		summary.liquidityNetSum.Add(summary.liquidityNetSum, liquidityNet)
+	case dbkey.KindCurrentTick:
+		summary.CurrentTick = entry.Value
+	case dbkey.KindTickSpacing:
+		summary.TickSpacing = entry.Value
+	case dbkey.KindPoolHeight:
+		summary.Height = entry.Value
+	case dbkey.KindActiveLiquidity:
+		summary.ActiveLiquidity = entry.Value
+	case dbkey.KindTickHistory:
+		summary.TickHistory++
+	case dbkey.KindCurrentTickHistory:
+		summary.CurrentTickHistory++
+	case dbkey.KindEvent:
+		summary.Events++
+	}
+}
+
+// write writes the summaries in pool address order
+func (s summaries) write(w *writer) error {
+	pools := make([]*poolSummary, 0, len(s))
+	for _, summary := range s {
+		summary.LiquidityNetSum = summary.liquidityNetSum.String()
+		pools = append(pools, summary)
+	}
+	sort.Slice(pools, func(i, j int) bool { return bytes.Compare(pools[i].Pool[:], pools[j].Pool[:]) < 0 })
+
+	for _, summary := range pools {
+		var err error
+		if w.format == FormatJSON {
+			err = w.json.Encode(summary)
+		} else {
+			err = w.row([]string{"pool", "ticks", "min_tick", "max_tick", "liquidity_net_sum", "current_tick", "tick_spacing", "height", "active_liquidity", "tick_history", "current_tick_history", "events", "errors"},
+				[]string{summary.Pool.Hex(), fmt.Sprint(summary.Ticks), formatPtr(summary.MinTick), formatPtr(summary.MaxTick), summary.LiquidityNetSum,
+					summary.CurrentTick, summary.TickSpacing, summary.Height, summary.ActiveLiquidity,
+					fmt.Sprint(summary.TickHistory), fmt.Sprint(summary.CurrentTickHistory), fmt.Sprint(summary.Events), fmt.Sprint(summary.Errors)})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
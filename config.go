@@ -29,12 +29,72 @@ type RedisConf struct {
 	Password string `json:"password"`
 }
 
+type EventReactorConf struct {
+	CheckLiquidity       bool   `json:"check_liquidity"`
+	HistoryRetention     uint64 `json:"history_retention"`      // blocks of tick history and stored events to keep, 0 keeps all
+	HistoryPruneInterval uint64 `json:"history_prune_interval"` // blocks between two pruning runs
+	StoreEvents          bool   `json:"store_events"`           // keep applied events for the /events api, pruned with history_retention
+
+	PoolRetention      uint64 `json:"pool_retention"`       // prune pools without events for this many blocks, 0 disables
+	PoolMinLiquidity   string `json:"pool_min_liquidity"`   // prune pools whose liquidity at every price, in range or not, is below, decimal, empty disables
+	PruneFilteredPools bool   `json:"prune_filtered_pools"` // prune pools filtered in the pair cache
+	PoolPruneInterval  uint64 `json:"pool_prune_interval"`  // blocks between two pool pruning runs
+	PoolPruneBatch     int    `json:"pool_prune_batch"`     // pools checked per step, the reactor waits for one step at most
+}
+
+type BootstrapConf struct {
+	Mode               string `json:"mode"` // lens or logs
+	LogsBlockRange     uint64 `json:"logs_block_range"`
+	TicksPageSize      uint64 `json:"ticks_page_size"`
+	MulticallBatchSize int    `json:"multicall_batch_size"`
+	LensOverride       bool   `json:"lens_override"`
+}
+
+type RetryConf struct {
+	InitialDelayMs    int `json:"initial_delay_ms"`
+	MaxDelayMs        int `json:"max_delay_ms"`
+	BreakerThreshold  int `json:"breaker_threshold"`
+	BreakerCooldownMs int `json:"breaker_cooldown_ms"`
+}
+
+type RateLimitConf struct {
+	RPS         float64 `json:"rps"` // 0 means unlimited
+	Burst       int     `json:"burst"`
+	MaxInFlight int     `json:"max_in_flight"` // 0 means unlimited
+}
+
+type EndpointRateLimitConf struct {
+	RateLimitConf
+	Calls map[string]*RateLimitConf `json:"calls"` // receipts, eth_call, get_logs, block_number
+}
+
+type RateLimitsConf struct {
+	Default   *EndpointRateLimitConf            `json:"default"`
+	Endpoints map[string]*EndpointRateLimitConf `json:"endpoints"` // by rpc url
+}
+
 type RocksDBConf struct {
 	EnableLog            bool   `json:"enable_log"`
 	BlockCacheSize       uint64 `json:"block_cache_size"`
 	WriteBufferSize      uint64 `json:"write_buffer_size"`
 	MaxWriteBufferNumber int    `json:"max_write_buffer_number"`
 	DBPath               string `json:"db_path"`
+	Backend              string `json:"backend"`        // rocksdb, leveldb or memory, the build default when empty
+	CheckpointDir        string `json:"checkpoint_dir"` // where /admin/checkpoint writes archives
+	SecondaryPath        string `json:"secondary_path"` // serve-only instance logs, a temp dir when empty
+	CatchUpIntervalMs    int    `json:"catch_up_interval_ms"`
+}
+
+type APIConf struct {
+	Addr      string `json:"addr"`       // listen address
+	AdminAddr string `json:"admin_addr"` // listen address of the admin routes, empty disables them
+}
+
+type BackupConf struct {
+	Dir             string `json:"dir"` // backup engine dir, empty disables backups
+	IntervalSeconds int    `json:"interval_seconds"`
+	Keep            int    `json:"keep"`   // backups to keep, 0 keeps all
+	Verify          bool   `json:"verify"` // verify each new backup
 }
 
 type Config struct {
@@ -43,6 +103,12 @@ type Config struct {
 	BlockCrawler *BlockCrawlerConf `json:"block_crawler"`
 	Redis        *RedisConf        `json:"redis"`
 	RocksDB      *RocksDBConf      `json:"rocksdb"`
+	EventReactor *EventReactorConf `json:"event_reactor"`
+	Bootstrap    *BootstrapConf    `json:"bootstrap"`
+	Retry        *RetryConf        `json:"retry"`
+	RateLimit    *RateLimitsConf   `json:"rate_limit"`
+	Backup       *BackupConf       `json:"backup"`
+	API          *APIConf          `json:"api"`
 }
 
 var (
@@ -73,6 +139,48 @@ var (
 			WriteBufferSize:      uint64(1024 * 1024 * 128),      // 128MB
 			MaxWriteBufferNumber: 2,
 			DBPath:               ".db",
+			Backend:              "",
+			CheckpointDir:        "checkpoints",
+			SecondaryPath:        "",
+			CatchUpIntervalMs:    1000,
+		},
+		EventReactor: &EventReactorConf{
+			CheckLiquidity:       true,
+			HistoryRetention:     0,
+			HistoryPruneInterval: 1000,
+			StoreEvents:          true,
+			PoolRetention:        0,
+			PoolMinLiquidity:     "",
+			PruneFilteredPools:   false,
+			PoolPruneInterval:    10000,
+			PoolPruneBatch:       100,
+		},
+		Bootstrap: &BootstrapConf{
+			Mode:               BootstrapModeLens,
+			LogsBlockRange:     5000,
+			TicksPageSize:      1000,
+			MulticallBatchSize: 20,
+			LensOverride:       false,
+		},
+		Retry: &RetryConf{
+			InitialDelayMs:    100,
+			MaxDelayMs:        10000,
+			BreakerThreshold:  10,
+			BreakerCooldownMs: 5000,
+		},
+		RateLimit: &RateLimitsConf{
+			Default:   &EndpointRateLimitConf{},
+			Endpoints: map[string]*EndpointRateLimitConf{},
+		},
+		Backup: &BackupConf{
+			Dir:             "",
+			IntervalSeconds: 3600,
+			Keep:            24,
+			Verify:          true,
+		},
+		API: &APIConf{
+			Addr:      ":29292",
+			AdminAddr: "",
 		},
 	}
 
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// testBackends are the KVStore backends of this build
+func testBackends() []string {
+	backends := []string{BackendMemory, BackendLevelDB}
+	if DefaultBackend == BackendRocksDB {
+		backends = append(backends, BackendRocksDB)
+	}
+	return backends
+}
+
+func newTestKVStore(t *testing.T, backend string) KVStore {
+	store, err := OpenKVStore(backend, t.TempDir(), &RocksDBOptions{
+		BlockCacheSize:       1024 * 1024 * 100,
+		WriteBufferSize:      1024 * 1024 * 10,
+		MaxWriteBufferNumber: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create %s db: %v", backend, err)
+	}
+	return store
+}
+
+// testBackendEnv names the backend of newTestRepo in the runs started by TestConformance_DB
+const testBackendEnv = "TEST_DB_BACKEND"
+
+// TestConformance_DB runs every test of the package again on each of the other
+// backends, so a new test on newTestRepo is covered without being listed here
+func TestConformance_DB(t *testing.T) {
+	if os.Getenv(testBackendEnv) != "" {
+		t.Skip("already running on a single backend")
+	}
+
+	for _, backend := range testBackends() {
+		if backend == DefaultBackend {
+			continue
+		}
+		t.Run(backend, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=.", "-test.skip=^TestConformance_")
+			cmd.Env = append(os.Environ(), testBackendEnv+"="+backend)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("suite failed on %s: %v\n%s", backend, err, out)
+			}
+		})
+	}
+}
+
+func conformanceKey(i int) []byte {
+	return append(append([]byte{}, KeyPrefixTickState...), []byte(fmt.Sprintf("%04d", i))...)
+}
+
+func TestConformance_KVStore(t *testing.T) {
+	for _, backend := range testBackends() {
+		t.Run(backend, func(t *testing.T) {
+			store := newTestKVStore(t, backend)
+			defer store.Close()
+
+			value, err := store.Get(conformanceKey(0))
+			if err != nil || value != nil {
+				t.Fatalf("Get of a missing key: want nil, got %x, %v", value, err)
+			}
+
+			for i := 1; i <= 9; i++ {
+				if err = store.Set(conformanceKey(i), []byte{byte(i)}); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+			}
+
+			// Scan includes both bounds
+			var keys [][]byte
+			err = store.Scan(conformanceKey(3), conformanceKey(6), func(key, value []byte) error {
+				keys = append(keys, append([]byte{}, key...))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if len(keys) != 4 || !bytes.Equal(keys[0], conformanceKey(3)) || !bytes.Equal(keys[3], conformanceKey(6)) {
+				t.Fatalf("Scan [3, 6]: got %q", keys)
+			}
+
+			count := 0
+			err = store.Scan(conformanceKey(1), conformanceKey(9), func(key, value []byte) error {
+				count++
+				if count == 2 {
+					return ErrStopScan
+				}
+				return nil
+			})
+			if err != nil || count != 2 {
+				t.Fatalf("Scan with ErrStopScan: want 2 entries, got %d, %v", count, err)
+			}
+
+			prev, err := store.GetPrev(conformanceKey(0), conformanceKey(5))
+			if err != nil || prev == nil || !bytes.Equal(prev.K(), conformanceKey(5)) || prev.V()[0] != 5 {
+				t.Fatalf("GetPrev: want key 5, got %v, %v", prev, err)
+			}
+			prev, err = store.GetPrev(conformanceKey(0), conformanceKey(0))
+			if err != nil || prev != nil {
+				t.Fatalf("GetPrev below the first key: want nil, got %v, %v", prev, err)
+			}
+
+			next, err := store.GetNext(conformanceKey(5), conformanceKey(20))
+			if err != nil || next == nil || !bytes.Equal(next.K(), conformanceKey(5)) {
+				t.Fatalf("GetNext: want key 5, got %v, %v", next, err)
+			}
+			next, err = store.GetNext(conformanceKey(10), conformanceKey(20))
+			if err != nil || next != nil {
+				t.Fatalf("GetNext above the last key: want nil, got %v, %v", next, err)
+			}
+
+			view, release := store.Snapshot()
+
+			// DeleteRange excludes its upper bound
+			batch := store.NewBatch()
+			batch.Put(conformanceKey(10), []byte{10})
+			batch.Delete(conformanceKey(1))
+			batch.DeleteRange(conformanceKey(4), conformanceKey(7))
+			if err = store.WriteBatch(batch); err != nil {
+				t.Fatalf("WriteBatch failed: %v", err)
+			}
+			batch.Destroy()
+
+			keys = nil
+			err = store.Scan(conformanceKey(0), conformanceKey(10), func(key, value []byte) error {
+				keys = append(keys, append([]byte{}, key...))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			want := [][]byte{conformanceKey(2), conformanceKey(3), conformanceKey(7), conformanceKey(8), conformanceKey(9), conformanceKey(10)}
+			if len(keys) != len(want) {
+				t.Fatalf("after WriteBatch: want %q, got %q", want, keys)
+			}
+			for i := range want {
+				if !bytes.Equal(keys[i], want[i]) {
+					t.Fatalf("after WriteBatch: want %q, got %q", want, keys)
+				}
+			}
+
+			// the view still reads the state before the batch
+			value, err = view.Get(conformanceKey(5))
+			if err != nil || !bytes.Equal(value, []byte{5}) {
+				t.Fatalf("snapshot Get: want 05, got %x, %v", value, err)
+			}
+			value, err = view.Get(conformanceKey(10))
+			if err != nil || value != nil {
+				t.Fatalf("snapshot Get of a later key: want nil, got %x, %v", value, err)
+			}
+			if again, _ := view.Snapshot(); again != view {
+				t.Fatalf("Snapshot of a view: want the view itself")
+			}
+			release()
+		})
+	}
+}
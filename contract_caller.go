@@ -5,93 +5,155 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"strings"
 	"time"
 	"uniswapv3-tick-state/abi_instance"
 
-	"github.com/avast/retry-go/v4"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"go.uber.org/zap"
 )
 
 type ContractCaller struct {
-	ethClient *ethclient.Client
+	ethClient          *ethclient.Client
+	gethClient         *gethclient.Client
+	retryPolicy        *RetryPolicy
+	rateLimiter        *RateLimiter
+	ticksPageSize      uint64
+	multicallBatchSize int
+	lensOverride       bool
 }
 
-func NewContractCaller(url string) *ContractCaller {
+// NewContractCaller creates a caller for the lens contract. With lensOverride the
+// embedded lens bytecode is run through eth_call state override instead of
+// calling the deployed lens at abi_instance.LensAddress.
+func NewContractCaller(url string, ticksPageSize uint64, multicallBatchSize int, lensOverride bool) *ContractCaller {
 	ethClient, err := ethclient.Dial(url)
 	if err != nil {
 		panic("failed to connect to Ethereum client: " + err.Error())
 	}
 
-	return &ContractCaller{
-		ethClient: ethClient,
+	if lensOverride && len(abi_instance.LensRuntimeCode) == 0 {
+		panic("lens bytecode is not embedded, run `make lens` first")
+	}
+
+	if ticksPageSize == 0 {
+		ticksPageSize = 1000
 	}
-}
 
-func IsRetryableErr(err error) bool {
-	errMsg := err.Error()
-	if strings.Contains(errMsg, "execution reverted") ||
-		strings.Contains(errMsg, "out of gas") ||
-		strings.Contains(errMsg, "abi: cannot marshal in to go slice") {
-		return false
+	if multicallBatchSize <= 0 {
+		multicallBatchSize = 20
+	}
+
+	return &ContractCaller{
+		ethClient:          ethClient,
+		gethClient:         gethclient.New(ethClient.Client()),
+		retryPolicy:        GetRetryPolicy(url),
+		rateLimiter:        GetRateLimiter(url),
+		ticksPageSize:      ticksPageSize,
+		multicallBatchSize: multicallBatchSize,
+		lensOverride:       lensOverride,
 	}
-	return true
 }
 
 func (c *ContractCaller) callContract(ctx context.Context, req *CallContractReq) ([]byte, error) {
-	bytes, err := c.ethClient.CallContract(
-		ctx,
-		ethereum.CallMsg{
-			To:   &req.Address,
-			Data: req.Data,
-		},
-		req.BlockNumber,
+	msg := ethereum.CallMsg{
+		To:   &req.Address,
+		Data: req.Data,
+	}
+
+	var (
+		bytes []byte
+		err   error
 	)
+	if len(req.Overrides) > 0 {
+		overrides := make(map[common.Address]gethclient.OverrideAccount, len(req.Overrides))
+		for addr, code := range req.Overrides {
+			overrides[addr] = gethclient.OverrideAccount{Code: code}
+		}
+		bytes, err = c.gethClient.CallContract(ctx, msg, req.BlockNumber, &overrides)
+	} else {
+		bytes, err = c.ethClient.CallContract(ctx, msg, req.BlockNumber)
+	}
 
 	if err != nil {
-		if IsRetryableErr(err) {
-			return nil, err
-		}
-		return nil, nil
+		return nil, err
 	}
 
 	return bytes, nil
 }
 
 const (
-	timeoutDuration = time.Minute * 5
+	timeoutDuration = time.Minute * 5 // retry budget of one eth_call
 )
 
 func (c *ContractCaller) CallContract(ctx context.Context, req *CallContractReq) ([]byte, error) {
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeoutDuration)
-	defer cancel()
-	return retry.DoWithData(func() ([]byte, error) {
-		return c.callContract(ctx, req)
-	}, infiniteAttempts, retryDelay, retry.Context(ctxWithTimeout))
+	return DoWithRetry(ctx, c.retryPolicy, timeoutDuration, func(ctx context.Context) ([]byte, error) {
+		return RateLimited(ctx, c.rateLimiter, CallTypeEthCall, PriorityLow, func(ctx context.Context) ([]byte, error) {
+			return c.callContract(ctx, req)
+		})
+	})
 }
 
 var (
-	getAllTicksMethod = abi_instance.LensABI.Methods["getAllTicks"]
+	getAllTicksMethod  = abi_instance.LensABI.Methods["getAllTicks"]
+	getPoolStateMethod = abi_instance.LensABI.Methods["getPoolState"]
+	getTicksMethod     = abi_instance.LensABI.Methods["getTicks"]
 )
 
 var (
 	ErrEmptyOutput = errors.New("empty output")
 )
 
+// abi decoded lens structs
+type (
+	lensPoolState = struct {
+		Height       *big.Int `json:"height"`
+		TickSpacing  *big.Int `json:"tickSpacing"`
+		Tick         *big.Int `json:"tick"`
+		Liquidity    *big.Int `json:"liquidity"`
+		SqrtPriceX96 *big.Int `json:"sqrtPriceX96"`
+	}
+
+	lensTick = struct {
+		Index          *big.Int `json:"index"`
+		LiquidityGross *big.Int `json:"liquidityGross"`
+		LiquidityNet   *big.Int `json:"liquidityNet"`
+	}
+)
+
+// GetPoolState loads the pool with a single getAllTicks call, and falls back
+// to paging through getTicks when that call fails.
 func (c *ContractCaller) GetPoolState(addr common.Address) (*PoolState, error) {
-	data, err := abi_instance.LensABI.Pack("getAllTicks", addr)
+	return c.getPoolState(addr, nil)
+}
+
+// getPoolState is GetPoolState at blockNumber, nil for the latest block
+func (c *ContractCaller) getPoolState(addr common.Address, blockNumber *big.Int) (*PoolState, error) {
+	poolState, err := c.getAllTicks(addr, blockNumber)
+	if err == nil {
+		return poolState, nil
+	}
+
+	Log.Warn("getAllTicks failed, fall back to getTicks", zap.String("addr", addr.String()), zap.Error(err))
+	return c.getPoolStatePaged(addr, blockNumber)
+}
+
+func (c *ContractCaller) callLens(method string, blockNumber *big.Int, args ...interface{}) ([]byte, error) {
+	data, err := abi_instance.LensABI.Pack(method, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	req := &CallContractReq{
-		Address: abi_instance.LensAddress,
-		Data:    data,
+		BlockNumber: blockNumber,
+		Address:     c.lensAddress(),
+		Data:        data,
+		Overrides:   c.lensOverrides(),
 	}
 
-	Log.Debug(fmt.Sprintf("Calling getAllTicks: %s", req))
+	Log.Debug(fmt.Sprintf("Calling %s: %s", method, req))
 	bytes, err := c.CallContract(context.Background(), req)
 	if err != nil {
 		return nil, err
@@ -101,24 +163,40 @@ func (c *ContractCaller) GetPoolState(addr common.Address) (*PoolState, error) {
 		return nil, ErrEmptyOutput
 	}
 
-	outputs, err := getAllTicksMethod.Outputs.Unpack(bytes)
+	return bytes, nil
+}
+
+func (c *ContractCaller) lensAddress() common.Address {
+	if c.lensOverride {
+		return abi_instance.LensOverrideAddress
+	}
+	return abi_instance.LensAddress
+}
+
+func (c *ContractCaller) lensOverrides() map[common.Address][]byte {
+	if !c.lensOverride {
+		return nil
+	}
+	return map[common.Address][]byte{abi_instance.LensOverrideAddress: abi_instance.LensRuntimeCode}
+}
+
+func (c *ContractCaller) getAllTicks(addr common.Address, blockNumber *big.Int) (*PoolState, error) {
+	bytes, err := c.callLens("getAllTicks", blockNumber, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	poolState := outputs[0].(struct {
-		Height       *big.Int `json:"height"`
-		TickSpacing  *big.Int `json:"tickSpacing"`
-		Tick         *big.Int `json:"tick"`
-		Liquidity    *big.Int `json:"liquidity"`
-		SqrtPriceX96 *big.Int `json:"sqrtPriceX96"`
-	})
+	return unpackAllTicks(bytes)
+}
 
-	ticks := outputs[1].([]struct {
-		Index          *big.Int `json:"index"`
-		LiquidityGross *big.Int `json:"liquidityGross"`
-		LiquidityNet   *big.Int `json:"liquidityNet"`
-	})
+func unpackAllTicks(bytes []byte) (*PoolState, error) {
+	outputs, err := getAllTicksMethod.Outputs.Unpack(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	poolState := outputs[0].(lensPoolState)
+	ticks := outputs[1].([]lensTick)
 
 	var tickStates []*TickState
 	for _, tick := range ticks {
@@ -137,3 +215,80 @@ func (c *ContractCaller) GetPoolState(addr common.Address) (*PoolState, error) {
 		TickStates: tickStates,
 	}, nil
 }
+
+// GetPoolStatePaged reads the pool state with getPoolState and then pages
+// through the initialized ticks with getTicks, all at the same block number.
+func (c *ContractCaller) GetPoolStatePaged(addr common.Address) (*PoolState, error) {
+	return c.getPoolStatePaged(addr, nil)
+}
+
+func (c *ContractCaller) getPoolStatePaged(addr common.Address, blockNumber *big.Int) (*PoolState, error) {
+	bytes, err := c.callLens("getPoolState", blockNumber, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := getPoolStateMethod.Outputs.Unpack(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	poolState := outputs[0].(lensPoolState)
+	tickSpacing := int32(poolState.TickSpacing.Int64())
+	if tickSpacing <= 0 {
+		return nil, fmt.Errorf("invalid tick spacing %d for pool %s", tickSpacing, addr)
+	}
+
+	var tickStates []*TickState
+	tickStart := MinTick / tickSpacing * tickSpacing
+	for tickStart <= MaxTick {
+		ticks, err := c.getTicks(addr, tickStart, poolState.Height)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tick := range ticks {
+			tickStates = append(tickStates, &TickState{
+				Tick:         int32(tick.Index.Int64()),
+				LiquidityNet: tick.LiquidityNet,
+			})
+		}
+
+		if uint64(len(ticks)) < c.ticksPageSize {
+			break
+		}
+		tickStart = tickStates[len(tickStates)-1].Tick + tickSpacing
+	}
+
+	return &PoolState{
+		Global: &PoolGlobalState{
+			Height:      poolState.Height,
+			TickSpacing: poolState.TickSpacing,
+			Tick:        poolState.Tick,
+		},
+		TickStates: tickStates,
+	}, nil
+}
+
+// getTicks returns at most ticksPageSize initialized ticks starting at tickStart
+func (c *ContractCaller) getTicks(addr common.Address, tickStart int32, blockNumber *big.Int) ([]lensTick, error) {
+	bytes, err := c.callLens("getTicks", blockNumber, addr, big.NewInt(int64(tickStart)), new(big.Int).SetUint64(c.ticksPageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := getTicksMethod.Outputs.Unpack(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lens returns a fixed size array, unused slots are zero ticks
+	ticks := outputs[0].([]lensTick)
+	for i, tick := range ticks {
+		if tick.LiquidityGross.Sign() == 0 {
+			return ticks[:i], nil
+		}
+	}
+
+	return ticks, nil
+}
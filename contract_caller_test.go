@@ -1,15 +1,332 @@
 package main
 
 import (
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/stretchr/testify/require"
-	"testing"
+
+	"uniswapv3-tick-state/abi_instance"
 )
 
 func TestGetAllTicks(t *testing.T) {
 	t.Skip()
-	cc := NewContractCaller("https://bsc-testnet-dataseed.bnbchain.org")
+	cc := NewContractCaller("https://bsc-testnet-dataseed.bnbchain.org", 0, 0, false)
 	poolState, err := cc.GetPoolState(common.HexToAddress("0x172fcD41E0913e95784454622d1c3724f546f849"))
 	require.Nil(t, err, err)
 	t.Log(poolState)
 }
+
+func TestGetPoolStatePaged(t *testing.T) {
+	t.Skip()
+	cc := NewContractCaller("https://bsc-testnet-dataseed.bnbchain.org", 10, 0, false)
+	poolState, err := cc.GetPoolStatePaged(common.HexToAddress("0x172fcD41E0913e95784454622d1c3724f546f849"))
+	require.Nil(t, err, err)
+	t.Log(poolState)
+}
+
+func TestGetPoolStates(t *testing.T) {
+	t.Skip()
+	cc := NewContractCaller("https://bsc-testnet-dataseed.bnbchain.org", 0, 2, false)
+	poolStates, err := cc.GetPoolStates([]common.Address{
+		common.HexToAddress("0x172fcD41E0913e95784454622d1c3724f546f849"),
+	})
+	require.Nil(t, err, err)
+	t.Log(poolStates)
+}
+
+// testRevertError is what a node answers for a reverted eth_call
+type testRevertError struct{}
+
+func (testRevertError) Error() string  { return "execution reverted" }
+func (testRevertError) ErrorCode() int { return 3 }
+
+type testCallArgs struct {
+	To    common.Address `json:"to"`
+	Input hexutil.Bytes  `json:"input"`
+	Data  hexutil.Bytes  `json:"data"`
+}
+
+type testOverrideAccount struct {
+	Code hexutil.Bytes `json:"code"`
+}
+
+// testPool is the state the test lens serves for one pool
+type testPool struct {
+	tick         int64
+	ticks        []int32 // initialized ticks in order, liquidityNet is the tick itself
+	failAllTicks bool
+	failTicks    bool
+}
+
+func (p *testPool) lensTicks(from int32, n int) []lensTick {
+	ticks := make([]lensTick, 0, n)
+	for _, tick := range p.ticks {
+		if tick >= from && len(ticks) < n {
+			ticks = append(ticks, lensTick{Index: big.NewInt(int64(tick)), LiquidityGross: big.NewInt(1), LiquidityNet: big.NewInt(int64(tick))})
+		}
+	}
+	return ticks
+}
+
+func (p *testPool) tickStates() []*TickState {
+	tickStates := make([]*TickState, 0, len(p.ticks))
+	for _, tick := range p.ticks {
+		tickStates = append(tickStates, &TickState{Tick: tick, LiquidityNet: big.NewInt(int64(tick))})
+	}
+	return tickStates
+}
+
+// testEthService is a node serving eth_blockNumber and eth_call of the lens and
+// Multicall3 contracts at a fixed height, it records every eth_call it answers
+type testEthService struct {
+	height uint64
+	pools  map[common.Address]*testPool
+
+	mu        sync.Mutex
+	calls     []string // "<method>@<block>"
+	targets   []common.Address
+	overrides []map[common.Address]testOverrideAccount
+}
+
+func newTestContractCaller(t *testing.T, s *testEthService, ticksPageSize uint64, multicallBatchSize int, lensOverride bool) *ContractCaller {
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", s))
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	t.Cleanup(server.Stop)
+	return NewContractCaller(httpServer.URL, ticksPageSize, multicallBatchSize, lensOverride)
+}
+
+func (s *testEthService) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(s.height)
+}
+
+func (s *testEthService) Call(args testCallArgs, block string, overrides *map[common.Address]testOverrideAccount) (hexutil.Bytes, error) {
+	data := args.Input
+	if len(data) == 0 {
+		data = args.Data
+	}
+
+	s.mu.Lock()
+	s.targets = append(s.targets, args.To)
+	if overrides != nil {
+		s.overrides = append(s.overrides, *overrides)
+	}
+	s.mu.Unlock()
+
+	if args.To == abi_instance.Multicall3Address {
+		return s.aggregate3(data, block)
+	}
+	return s.callLens(data, block)
+}
+
+func (s *testEthService) record(method, block string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, method+"@"+block)
+}
+
+func (s *testEthService) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func (s *testEthService) callLens(data []byte, block string) ([]byte, error) {
+	method, err := abi_instance.LensABI.MethodById(data)
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	s.record(method.Name, block)
+
+	pool := s.pools[args[0].(common.Address)]
+	if pool == nil {
+		return nil, testRevertError{}
+	}
+	global := lensPoolState{
+		Height:       new(big.Int).SetUint64(s.height),
+		TickSpacing:  big.NewInt(60),
+		Tick:         big.NewInt(pool.tick),
+		Liquidity:    big.NewInt(0),
+		SqrtPriceX96: big.NewInt(0),
+	}
+
+	switch method.Name {
+	case "getAllTicks":
+		if pool.failAllTicks {
+			return nil, testRevertError{}
+		}
+		return method.Outputs.Pack(global, pool.lensTicks(MinTick, len(pool.ticks)))
+
+	case "getPoolState":
+		return method.Outputs.Pack(global)
+
+	case "getTicks":
+		if pool.failTicks {
+			return nil, testRevertError{}
+		}
+		// a fixed size page, the unused slots are zero ticks
+		n := int(args[2].(*big.Int).Int64())
+		ticks := pool.lensTicks(int32(args[1].(*big.Int).Int64()), n)
+		for len(ticks) < n {
+			ticks = append(ticks, lensTick{Index: big.NewInt(0), LiquidityGross: big.NewInt(0), LiquidityNet: big.NewInt(0)})
+		}
+		return method.Outputs.Pack(ticks)
+	}
+	return nil, fmt.Errorf("unexpected lens method %s", method.Name)
+}
+
+func (s *testEthService) aggregate3(data []byte, block string) ([]byte, error) {
+	s.record("aggregate3", block)
+
+	args, err := aggregate3Method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	var calls []abi_instance.Multicall3Call
+	if err = aggregate3Method.Inputs.Copy(&calls, args); err != nil {
+		return nil, err
+	}
+
+	results := make([]multicall3Result, 0, len(calls))
+	for _, call := range calls {
+		returnData, err := s.callLens(call.CallData, block)
+		results = append(results, multicall3Result{Success: err == nil, ReturnData: returnData})
+	}
+	return aggregate3Method.Outputs.Pack(results)
+}
+
+func TestGetPoolStatePaged_Pages(t *testing.T) {
+	addr := common.HexToAddress("0x2a0000000000000000000000000000000000002a")
+	block := hexutil.EncodeUint64(500)
+
+	cases := []struct {
+		name  string
+		ticks []int32
+		pages int
+	}{
+		{"no ticks", nil, 1},
+		{"last page short", []int32{-120, -60, 0, 60, 120}, 3},
+		{"last page empty", []int32{-120, -60, 0, 60}, 3},
+		{"full page up to the max tick", []int32{-887220, -60, 60, 887220}, 2},
+		{"page boundary at the adjacent tick", []int32{-60, 0, 60}, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &testEthService{height: 500, pools: map[common.Address]*testPool{addr: {tick: 10, ticks: c.ticks}}}
+			cc := newTestContractCaller(t, s, 2, 0, false)
+
+			poolState, err := cc.GetPoolStatePaged(addr)
+			require.NoError(t, err)
+			require.Equal(t, uint64(500), poolState.Global.Height.Uint64())
+			require.Equal(t, int64(10), poolState.Global.Tick.Int64())
+			require.Equal(t, tickStatesToMap(s.pools[addr].tickStates()), tickStatesToMap(poolState.TickStates))
+
+			// every page is read at the block of getPoolState
+			calls := s.Calls()
+			require.Len(t, calls, c.pages+1)
+			require.Equal(t, "getPoolState@latest", calls[0])
+			for _, call := range calls[1:] {
+				require.Equal(t, "getTicks@"+block, call)
+			}
+		})
+	}
+}
+
+func TestGetPoolState_FallBackToGetTicks(t *testing.T) {
+	ok := common.HexToAddress("0x2b0000000000000000000000000000000000002b")
+	large := common.HexToAddress("0x2c0000000000000000000000000000000000002c")
+	broken := common.HexToAddress("0x2d0000000000000000000000000000000000002d")
+	s := &testEthService{height: 600, pools: map[common.Address]*testPool{
+		ok:     {ticks: []int32{-60, 60}},
+		large:  {ticks: []int32{-180, -120, -60, 60, 120, 180}, failAllTicks: true},
+		broken: {ticks: []int32{-60, 60}, failAllTicks: true, failTicks: true},
+	}}
+	cc := newTestContractCaller(t, s, 4, 0, false)
+
+	poolState, err := cc.GetPoolState(ok)
+	require.NoError(t, err)
+	require.Equal(t, map[int32]int64{-60: -60, 60: 60}, tickStatesToMap(poolState.TickStates))
+	require.Equal(t, []string{"getAllTicks@latest"}, s.Calls())
+
+	poolState, err = cc.GetPoolState(large)
+	require.NoError(t, err)
+	require.Equal(t, tickStatesToMap(s.pools[large].tickStates()), tickStatesToMap(poolState.TickStates))
+	methods := s.Calls()[1:]
+	sort.Strings(methods)
+	require.Equal(t, []string{"getAllTicks@latest", "getPoolState@latest", "getTicks@0x258", "getTicks@0x258"}, methods)
+
+	_, err = cc.GetPoolState(broken)
+	require.ErrorIs(t, err, ErrRPCPermanent)
+}
+
+func TestContractCaller_LensOverride(t *testing.T) {
+	code := strings.TrimSpace(abi_instance.LensRuntimeHex)
+	require.NotEmpty(t, code, "lens bytecode is embedded")
+	require.Equal(t, hexutil.MustDecode("0x"+code), abi_instance.LensRuntimeCode)
+
+	addr := common.HexToAddress("0x2e0000000000000000000000000000000000002e")
+	s := &testEthService{height: 700, pools: map[common.Address]*testPool{addr: {ticks: []int32{-60, 60}}}}
+	cc := newTestContractCaller(t, s, 0, 0, true)
+
+	_, err := cc.GetPoolState(addr)
+	require.NoError(t, err)
+	_, err = cc.GetPoolStates([]common.Address{addr})
+	require.NoError(t, err)
+
+	// the lens is called at the scratch address with the bytecode injected there
+	require.Equal(t, []common.Address{abi_instance.LensOverrideAddress, abi_instance.Multicall3Address}, s.targets)
+	require.Len(t, s.overrides, 2)
+	for _, overrides := range s.overrides {
+		require.Len(t, overrides, 1)
+		require.Equal(t, abi_instance.LensRuntimeCode, []byte(overrides[abi_instance.LensOverrideAddress].Code))
+	}
+
+	// without the override nothing is injected
+	s = &testEthService{height: 700, pools: map[common.Address]*testPool{addr: {ticks: []int32{-60, 60}}}}
+	cc = newTestContractCaller(t, s, 0, 0, false)
+	_, err = cc.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, []common.Address{abi_instance.LensAddress}, s.targets)
+	require.Empty(t, s.overrides)
+}
+
+func TestGetPoolStates_PartialFailure(t *testing.T) {
+	addrs := make([]common.Address, 5)
+	for i := range addrs {
+		addrs[i] = common.HexToAddress(fmt.Sprintf("0x3%039x", i+1))
+	}
+	s := &testEthService{height: 800, pools: map[common.Address]*testPool{
+		addrs[0]: {ticks: []int32{-60, 60}},
+		addrs[1]: {ticks: []int32{-120, 120}, failAllTicks: true},                // fails in the aggregate, paged fallback works
+		addrs[2]: {ticks: []int32{-60, 60}, failAllTicks: true, failTicks: true}, // fails everywhere
+		addrs[4]: {ticks: []int32{0, 180}},
+	}} // addrs[3] is not a pool
+	cc := newTestContractCaller(t, s, 0, 2, false)
+
+	poolStates, err := cc.GetPoolStates(addrs)
+	require.NoError(t, err)
+	require.Len(t, poolStates, 3)
+	for _, i := range []int{0, 1, 4} {
+		require.Equal(t, tickStatesToMap(s.pools[addrs[i]].tickStates()), tickStatesToMap(poolStates[addrs[i]].TickStates), "pool %d", i)
+	}
+
+	// the aggregates and every fallback run at the block of the first eth_blockNumber
+	block := hexutil.EncodeUint64(800)
+	for _, call := range s.Calls() {
+		require.Contains(t, call, "@"+block)
+	}
+}
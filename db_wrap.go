@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/linxGnu/grocksdb"
+
+	"uniswapv3-tick-state/dbkey"
 )
 
 var (
-	HeightKey            = []byte("1:")
-	KeyPrefixTickState   = []byte("2:")
-	KeyPrefixCurrentTick = []byte("3:")
-	KeyPrefixTickSpacing = []byte("4:")
-	KeyPrefixPoolHeight  = []byte("5:")
+	HeightKey            = dbkey.HeightKey
+	KeyPrefixTickState   = dbkey.PrefixTickState
+	KeyPrefixCurrentTick = dbkey.PrefixCurrentTick
+	KeyPrefixTickSpacing = dbkey.PrefixTickSpacing
+	KeyPrefixPoolHeight  = dbkey.PrefixPoolHeight
+
+	KeyPrefixTickHistory        = dbkey.PrefixTickHistory
+	KeyPrefixCurrentTickHistory = dbkey.PrefixCurrentTickHistory
+	HistoryFloorKey             = dbkey.HistoryFloorKey
+	KeyPrefixBlockTime          = dbkey.PrefixBlockTime
+	KeyPrefixActiveLiquidity    = dbkey.PrefixActiveLiquidity
 )
 
 func makeCurrentTickKey(addr common.Address) [22]byte {
@@ -44,6 +52,10 @@ type DB interface {
 	SetTickState(addr common.Address, tickState *TickState) error
 	GetTickState(addr common.Address, tick int32) (*TickState, error)
 	GetTickStates(addr common.Address) ([]*TickState, error)
+	ScanTickStates(addr common.Address, fromTick, toTick int32, fn func(tickState *TickState) error) error
+	GetTickStatesInRange(addr common.Address, fromTick, toTick int32) (*TickRange, error)
+	SetActiveLiquidity(addr common.Address, liquidity *big.Int) error
+	GetActiveLiquidity(addr common.Address) (*big.Int, error)
 	SetCurrentTick(addr common.Address, tick int32) error
 	GetCurrentTick(addr common.Address) (int32, error)
 	SetTickSpacing(addr common.Address, tickSpacing int32) error
@@ -51,26 +63,50 @@ type DB interface {
 	SetHeight(addr common.Address, height uint64) error
 	GetHeight(addr common.Address) (uint64, error)
 	GetPoolState(addr common.Address) (*PoolState, error)
+	GetPoolStateInRange(addr common.Address, tickOffset int32) (*PoolState, error)
 	SetPoolState(addr common.Address, poolTicks *PoolState) error
 	DeletePoolState(addr common.Address) error
+	PrunePool(addr common.Address, height uint64) error
+	WriteBlock(w *BlockWrite) error
+
+	SetTickHistory(addr common.Address, height uint64, tickState *TickState) error
+	SetCurrentTickHistory(addr common.Address, height uint64, tick int32) error
+	GetPoolStateAt(addr common.Address, height uint64) (*PoolState, error)
+	PruneHistory(ctx context.Context, before uint64) error
+
+	SetBlockTime(height uint64, timestamp uint64) error
+	GetBlockTime(height uint64) (uint64, error)
+	GetHeightAt(timestamp uint64) (uint64, error)
+
+	AddEvents(addr common.Address, records []*EventRecord) error
+	GetEvents(query *EventQuery) (*EventPage, error)
+
+	ListPools(query *PoolQuery) (*PoolPage, error)
 
 	Close()
 }
 
+// rocksDBWrap lays the DB out over a KVStore, RocksDB or another backend
 type rocksDBWrap struct {
-	db *RocksDB
+	db KVStore
 }
 
 func (r *rocksDBWrap) Close() {
 	r.db.Close()
 }
 
-func NewDB(db *RocksDB) DB {
+func NewDB(db KVStore) DB {
 	return &rocksDBWrap{
 		db: db,
 	}
 }
 
+// view returns the wrap on a snapshot of the db, for reads that span several keys
+func (r *rocksDBWrap) view() (*rocksDBWrap, func()) {
+	db, release := r.db.Snapshot()
+	return &rocksDBWrap{db: db}, release
+}
+
 func (r *rocksDBWrap) SetTickState(addr common.Address, tickState *TickState) error {
 	key := GetTickStateKey(addr, tickState.Tick).GetKey()
 	value, err := tickState.MarshalBinary()
@@ -99,53 +135,29 @@ func (r *rocksDBWrap) GetTickState(addr common.Address, tick int32) (*TickState,
 	return tickState, nil
 }
 
-type TickStateCollector struct {
-	collection map[common.Address][]*TickState
-}
-
-func NewTickStateCollector() *TickStateCollector {
-	return &TickStateCollector{
-		collection: make(map[common.Address][]*TickState),
-	}
-}
-
-func (c *TickStateCollector) Add(addr common.Address, tickState *TickState) {
-	if _, exists := c.collection[addr]; !exists {
-		c.collection[addr] = make([]*TickState, 0)
-	}
-	c.collection[addr] = append(c.collection[addr], tickState)
-}
-
-func (c *TickStateCollector) Get() map[common.Address][]*TickState {
-	return c.collection
-}
-
-func (r *rocksDBWrap) GetRange(from, to []byte) (map[common.Address][]*TickState, error) {
-	entries, err := r.db.GetRange(from, to)
-	if err != nil {
-		return nil, err
-	}
-
-	collector := NewTickStateCollector()
-	for _, entry := range entries {
-		key := BytesToTickStateKey(entry.K())
-		tickState := NewTickState(key.GetTick())
-		if err := tickState.UnmarshalBinary(entry.V()); err != nil {
-			return nil, err
+// ScanTickStates streams the ticks of a pool in [fromTick, toTick] in tick order,
+// fn returns ErrStopScan to stop early
+func (r *rocksDBWrap) ScanTickStates(addr common.Address, fromTick, toTick int32, fn func(tickState *TickState) error) error {
+	from, to := GetTickStateKey(addr, fromTick), GetTickStateKey(addr, toTick)
+	return r.db.Scan(from.GetKey(), to.GetKey(), func(key, value []byte) error {
+		tickState := NewTickState(BytesToTickStateKey(key).GetTick())
+		if err := tickState.UnmarshalBinary(value); err != nil {
+			return err
 		}
-		collector.Add(key.GetAddress(), tickState)
-	}
-
-	return collector.Get(), nil
+		return fn(tickState)
+	})
 }
 
 func (r *rocksDBWrap) GetTickStates(addr common.Address) ([]*TickState, error) {
-	tickStatesByAddr, err := r.GetRange(GetTickStateKey(addr, MinTick).GetKey(), GetTickStateKey(addr, MaxTick).GetKey())
+	var tickStates []*TickState
+	err := r.ScanTickStates(addr, MinTick, MaxTick, func(tickState *TickState) error {
+		tickStates = append(tickStates, tickState)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	return tickStatesByAddr[addr], nil
+	return tickStates, nil
 }
 
 func (r *rocksDBWrap) SetFinishHeight(height uint64) error {
@@ -169,13 +181,11 @@ func (r *rocksDBWrap) GetFinishHeight() (uint64, error) {
 }
 
 func int32ToBytes(n int32) []byte {
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(n))
-	return buf
+	return int32ToOrderedBytes(n)
 }
 
 func bytesToInt32(data []byte) int32 {
-	return int32(binary.BigEndian.Uint32(data))
+	return orderedBytesToInt32(data)
 }
 
 func uint64ToBytes(n uint64) []byte {
@@ -245,8 +255,31 @@ func (r *rocksDBWrap) GetHeight(addr common.Address) (uint64, error) {
 	return bytesToUint64(bytes), nil
 }
 
+// GetPoolState reads the pool from one snapshot. The state holds as of the finish
+// height, or of the pool height when it was bootstrapped ahead of the blocks.
 func (r *rocksDBWrap) GetPoolState(addr common.Address) (*PoolState, error) {
+	v, release := r.view()
+	defer release()
+	return v.getPoolState(addr)
+}
+
+// stateHeight is the height the stored pool state represents, 0 if the pool is unknown
+func (r *rocksDBWrap) stateHeight(addr common.Address) (uint64, error) {
 	height, err := r.GetHeight(addr)
+	if err != nil || height == 0 {
+		return 0, err
+	}
+
+	finishHeight, err := r.GetFinishHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	return max(height, finishHeight), nil
+}
+
+func (r *rocksDBWrap) getPoolState(addr common.Address) (*PoolState, error) {
+	height, err := r.stateHeight(addr)
 	if err != nil {
 		return nil, err
 	}
@@ -272,7 +305,7 @@ func (r *rocksDBWrap) GetPoolState(addr common.Address) (*PoolState, error) {
 
 	return &PoolState{
 		Global: &PoolGlobalState{
-			Height:      big.NewInt(int64(height)),
+			Height:      new(big.Int).SetUint64(height),
 			TickSpacing: big.NewInt(int64(tickSpacing)),
 			Tick:        big.NewInt(int64(tick)),
 		},
@@ -281,7 +314,7 @@ func (r *rocksDBWrap) GetPoolState(addr common.Address) (*PoolState, error) {
 }
 
 func (r *rocksDBWrap) SetPoolState(addr common.Address, poolState *PoolState) error {
-	batch := grocksdb.NewWriteBatch()
+	batch := r.db.NewBatch()
 	defer batch.Destroy()
 
 	heightKey := makePoolHeightKey(addr)
@@ -293,6 +326,21 @@ func (r *rocksDBWrap) SetPoolState(addr common.Address, poolState *PoolState) er
 	spacingKey := makeTickSpacingKey(addr)
 	batch.Put(spacingKey[:], int32ToBytes(int32(poolState.Global.TickSpacing.Int64())))
 
+	activeLiquidity, err := int128ToBytes(sumLiquidityNetUpTo(poolState.TickStates, int32(poolState.Global.Tick.Int64())))
+	if err != nil {
+		return err
+	}
+	activeLiquidityKey := makeActiveLiquidityKey(addr)
+	batch.Put(activeLiquidityKey[:], activeLiquidity)
+
+	if err = r.indexPoolState(addr, poolState, batch); err != nil {
+		return err
+	}
+
+	height := poolState.Global.Height.Uint64()
+	currentTickHistoryKey := makeCurrentTickHistoryKey(addr, height)
+	batch.Put(currentTickHistoryKey[:], int32ToBytes(int32(poolState.Global.Tick.Int64())))
+
 	for _, ts := range poolState.TickStates {
 		tickStateKey := GetTickStateKey(addr, ts.Tick).GetKey()
 		value, err := ts.MarshalBinary()
@@ -300,27 +348,98 @@ func (r *rocksDBWrap) SetPoolState(addr common.Address, poolState *PoolState) er
 			return err
 		}
 		batch.Put(tickStateKey, value)
+
+		tickHistoryKey := makeTickHistoryKey(addr, ts.Tick, height)
+		batch.Put(tickHistoryKey[:], value)
 	}
 
 	return r.db.WriteBatch(batch)
 }
 
+// DeletePoolState deletes the pool state and its history
 func (r *rocksDBWrap) DeletePoolState(addr common.Address) error {
-	batch := grocksdb.NewWriteBatch()
+	return r.deletePoolState(addr, 0, 0)
+}
+
+// PrunePool deletes the live pool state after the block at height like the
+// quarantine in WriteBlock and leaves a tombstone at height in the pool index,
+// in the same batch
+func (r *rocksDBWrap) PrunePool(addr common.Address, height uint64) error {
+	return r.deletePoolState(addr, height+1, height)
+}
+
+func (r *rocksDBWrap) deletePoolState(addr common.Address, height uint64, prunedHeight uint64) error {
+	batch := r.db.NewBatch()
 	defer batch.Destroy()
 
+	if err := r.deletePoolStateInBatch(addr, height, prunedHeight, batch); err != nil {
+		return err
+	}
+	return r.db.WriteBatch(batch)
+}
+
+// deletePoolStateInBatch records the deletion of the live pool state into batch.
+// A non-zero height keeps the history and the tick spacing, GetPoolStateAt still
+// answers below height: the live ticks get a zero version and the current tick
+// history the unknown marker at height, the pool is unknown from there until it
+// is bootstrapped again. Without a height the history is deleted too. A non-zero
+// prunedHeight leaves a tombstone in the pool index.
+func (r *rocksDBWrap) deletePoolStateInBatch(addr common.Address, height uint64, prunedHeight uint64, batch KVBatch) error {
 	heightKey := makePoolHeightKey(addr)
 	batch.Delete(heightKey[:])
 
-	spacingKey := makeTickSpacingKey(addr)
-	batch.Delete(spacingKey[:])
-
 	tickKey := makeCurrentTickKey(addr)
 	batch.Delete(tickKey[:])
 
+	activeLiquidityKey := makeActiveLiquidityKey(addr)
+	batch.Delete(activeLiquidityKey[:])
+
+	if height != 0 {
+		tickStates, err := r.GetTickStates(addr)
+		if err != nil {
+			return err
+		}
+
+		zero, err := NewTickState(0).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		for _, ts := range tickStates {
+			tickHistoryKey := makeTickHistoryKey(addr, ts.Tick, height)
+			batch.Put(tickHistoryKey[:], zero)
+		}
+
+		currentTickHistoryKey := makeCurrentTickHistoryKey(addr, height)
+		batch.Put(currentTickHistoryKey[:], unknownCurrentTick)
+	} else {
+		spacingKey := makeTickSpacingKey(addr)
+		batch.Delete(spacingKey[:])
+
+		tickHistoryStart, tickHistoryEnd := tickHistoryRange(addr)
+		batch.DeleteRange(tickHistoryStart[:], tickHistoryEnd[:])
+
+		currentTickHistoryStart, currentTickHistoryEnd := currentTickHistoryRange(addr)
+		batch.DeleteRange(currentTickHistoryStart[:], currentTickHistoryEnd[:])
+	}
+
 	startKey := GetTickStateKey(addr, MinTick).GetKey()
 	endKey := GetTickStateKey(addr, MaxTick).GetKey()
 	batch.DeleteRange(startKey, endKey)
 
-	return r.db.WriteBatch(batch)
+	// the index entry stays, the pool is known but holds no ticks until it is bootstrapped again
+	info, err := getPoolInfo(r.db, addr)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		prevKeys := info.sortKeys()
+		info.TickCount = 0
+		if prunedHeight != 0 {
+			info.PrunedHeight = prunedHeight
+		}
+		if err = putPoolInfo(batch, prevKeys, info); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"os"
 	"testing"
 )
 
-func newTestRepo(t *testing.T) DB {
+func newTestRocksDB(t *testing.T) *RocksDB {
 	name := t.TempDir()
 	db, err := NewRocksDB(name, &RocksDBOptions{
 		EnableLog:            false,
@@ -17,7 +19,23 @@ func newTestRepo(t *testing.T) DB {
 	if err != nil {
 		t.Fatalf("failed to create rocksdb: %v", err)
 	}
-	return NewDB(db)
+	return db
+}
+
+// testBackend is the KVStore backend of newTestRepo, the build default unless
+// TestConformance_DB runs the suite on another backend
+var testBackend = cmp.Or(os.Getenv(testBackendEnv), DefaultBackend)
+
+func newTestRepo(t *testing.T) DB {
+	store, err := OpenKVStore(testBackend, t.TempDir(), &RocksDBOptions{
+		BlockCacheSize:       1024 * 1024 * 100,
+		WriteBufferSize:      1024 * 1024 * 10,
+		MaxWriteBufferNumber: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create %s db: %v", testBackend, err)
+	}
+	return NewDB(store)
 }
 
 func Test_SetTickState_GetTickState_PositiveNegative(t *testing.T) {
@@ -154,3 +172,57 @@ func Test_Close(t *testing.T) {
 	repo := newTestRepo(t)
 	repo.Close()
 }
+
+func Test_GetTickStatesInRange(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	addr := common.HexToAddress("0x9000000000000000000000000000000000000009")
+
+	var tickStates []*TickState
+	for _, tick := range []int32{-600, -300, -120, -60, 0, 60, 180, 420, 900} {
+		tickStates = append(tickStates, &TickState{Tick: tick, LiquidityNet: big.NewInt(int64(1000 - tick))})
+	}
+
+	for _, currentTick := range []int32{-1000, -100, 0, 59, 500, 1000} {
+		err := repo.SetPoolState(addr, &PoolState{
+			Global:     &PoolGlobalState{Height: big.NewInt(1), TickSpacing: big.NewInt(60), Tick: big.NewInt(int64(currentTick))},
+			TickStates: tickStates,
+		})
+		if err != nil {
+			t.Fatalf("SetPoolState failed: %v", err)
+		}
+
+		for _, window := range [][2]int32{{-200, 100}, {-1000, -700}, {1000, 2000}, {-60, 60}, {200, 400}} {
+			tickRange, err := repo.GetTickStatesInRange(addr, window[0], window[1])
+			if err != nil {
+				t.Fatalf("GetTickStatesInRange failed: %v", err)
+			}
+
+			want := FilterRangeLiquidityArray(BuildRangeLiquidityArray(tickStates), window[0], window[1])
+			got := FilterRangeLiquidityArray(BuildRangeLiquidityArrayFrom(tickRange.BaseLiquidity, tickRange.TickStates), window[0], window[1])
+			if len(want) != len(got) {
+				t.Fatalf("current %d, window %v: want %d ranges, got %d", currentTick, window, len(want), len(got))
+			}
+			for i := range want {
+				if want[i].TickLower != got[i].TickLower || want[i].TickUpper != got[i].TickUpper || want[i].Liquidity.Cmp(got[i].Liquidity) != 0 {
+					t.Fatalf("current %d, window %v: want %+v, got %+v", currentTick, window, want[i], got[i])
+				}
+			}
+		}
+	}
+
+	// a pool without the checkpoint sums from MinTick
+	other := common.HexToAddress("0x900000000000000000000000000000000000000a")
+	for _, ts := range tickStates {
+		if err := repo.SetTickState(other, ts); err != nil {
+			t.Fatalf("SetTickState failed: %v", err)
+		}
+	}
+	tickRange, err := repo.GetTickStatesInRange(other, 0, 100)
+	if err != nil {
+		t.Fatalf("GetTickStatesInRange failed: %v", err)
+	}
+	if len(tickRange.TickStates) != 4 || tickRange.TickStates[0].Tick != -60 || tickRange.BaseLiquidity.Int64() != 1600+1300+1120 {
+		t.Fatalf("GetTickStatesInRange without checkpoint: got %d ticks, base %s", len(tickRange.TickStates), tickRange.BaseLiquidity)
+	}
+}
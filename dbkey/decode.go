@@ -0,0 +1,210 @@
+package dbkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Kinds of Entry, one per key prefix
+const (
+	KindSchemaVersion      = "schema_version"
+	KindMigration          = "migration"
+	KindFinishHeight       = "finish_height"
+	KindTickState          = "tick_state"
+	KindCurrentTick        = "current_tick"
+	KindTickSpacing        = "tick_spacing"
+	KindPoolHeight         = "pool_height"
+	KindTickHistory        = "tick_history"
+	KindCurrentTickHistory = "current_tick_history"
+	KindHistoryFloor       = "history_floor"
+	KindBlockTime          = "block_time"
+	KindEvent              = "event"
+	KindActiveLiquidity    = "active_liquidity"
+	KindPoolInfo           = "pool_info"
+	KindPoolByActivity     = "pool_by_activity"
+	KindPoolByTicks        = "pool_by_ticks"
+	KindUnknown            = "unknown"
+)
+
+var prefixKinds = map[string]string{
+	string(PrefixTickState):          KindTickState,
+	string(PrefixCurrentTick):        KindCurrentTick,
+	string(PrefixTickSpacing):        KindTickSpacing,
+	string(PrefixPoolHeight):         KindPoolHeight,
+	string(PrefixTickHistory):        KindTickHistory,
+	string(PrefixCurrentTickHistory): KindCurrentTickHistory,
+	string(PrefixBlockTime):          KindBlockTime,
+	string(PrefixEvent):              KindEvent,
+	string(PrefixActiveLiquidity):    KindActiveLiquidity,
+	string(PrefixPoolInfo):           KindPoolInfo,
+	string(PrefixPoolByActivity):     KindPoolByActivity,
+	string(PrefixPoolByTicks):        KindPoolByTicks,
+}
+
+// Entry is a decoded key-value pair. Value is the decoded value as text, the
+// hex of the raw value when the entry does not decode, with the reason in Error.
+type Entry struct {
+	Kind     string          `json:"kind"`
+	Key      string          `json:"key"`
+	Pool     *common.Address `json:"pool,omitempty"`
+	Tick     *int32          `json:"tick,omitempty"`
+	Height   *uint64         `json:"height,omitempty"`
+	LogIndex *uint32         `json:"logIndex,omitempty"`
+	Value    string          `json:"value"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Decode decodes any key of the layout in key.go, it never panics on malformed keys or values
+func Decode(key, value []byte) *Entry {
+	entry := &Entry{Kind: KindOf(key), Key: hex.EncodeToString(key)}
+
+	var err error
+	if err = entry.decodeKey(key); err == nil {
+		err = entry.decodeValue(value)
+	}
+	if err != nil {
+		entry.Value = hex.EncodeToString(value)
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// KindOf is the kind of the key by its prefix
+func KindOf(key []byte) string {
+	switch {
+	case bytes.Equal(key, SchemaVersionKey):
+		return KindSchemaVersion
+	case bytes.Equal(key, MigrationProgressKey):
+		return KindMigration
+	case bytes.Equal(key, HeightKey):
+		return KindFinishHeight
+	case bytes.Equal(key, HistoryFloorKey):
+		return KindHistoryFloor
+	}
+
+	if len(key) >= PrefixLen {
+		if kind, ok := prefixKinds[string(key[:PrefixLen])]; ok {
+			return kind
+		}
+	}
+	return KindUnknown
+}
+
+// keyLens are the key lengths of the kinds with fields after the prefix
+var keyLens = map[string]int{
+	KindTickState:          TickStateKeyLen,
+	KindCurrentTick:        PoolKeyLen,
+	KindTickSpacing:        PoolKeyLen,
+	KindPoolHeight:         PoolKeyLen,
+	KindTickHistory:        TickHistoryKeyLen,
+	KindCurrentTickHistory: CurrentTickHistoryKeyLen,
+	KindBlockTime:          BlockTimeKeyLen,
+	KindEvent:              EventKeyLen,
+	KindActiveLiquidity:    PoolKeyLen,
+	KindPoolInfo:           PoolKeyLen,
+	KindPoolByActivity:     PoolSortKeyLen,
+	KindPoolByTicks:        PoolSortKeyLen,
+}
+
+func (e *Entry) decodeKey(key []byte) error {
+	if e.Kind == KindUnknown {
+		return fmt.Errorf("unknown key prefix")
+	}
+
+	keyLen, ok := keyLens[e.Kind]
+	if !ok {
+		return nil
+	}
+	if len(key) != keyLen {
+		return fmt.Errorf("%s key of %d bytes, want %d", e.Kind, len(key), keyLen)
+	}
+
+	switch {
+	case e.Kind == KindPoolByActivity || e.Kind == KindPoolByTicks:
+		// the sort value comes before the pool
+		pool := common.BytesToAddress(key[10:30])
+		e.Pool = &pool
+	case keyLen >= PoolKeyLen && e.Kind != KindBlockTime:
+		pool := common.BytesToAddress(key[PrefixLen:PoolKeyLen])
+		e.Pool = &pool
+	}
+
+	switch e.Kind {
+	case KindTickState:
+		e.setTick(key[22:26])
+	case KindTickHistory:
+		e.setTick(key[22:26])
+		e.setHeight(key[26:34])
+	case KindCurrentTickHistory:
+		e.setHeight(key[22:30])
+	case KindBlockTime:
+		e.setHeight(key[2:10])
+	case KindEvent:
+		e.setHeight(key[22:30])
+		logIndex := binary.BigEndian.Uint32(key[30:34])
+		e.LogIndex = &logIndex
+	case KindPoolByActivity:
+		height := ^binary.BigEndian.Uint64(key[2:10])
+		e.Height = &height
+	}
+	return nil
+}
+
+func (e *Entry) setTick(b []byte) {
+	tick := OrderedBytesToInt32(b)
+	e.Tick = &tick
+}
+
+func (e *Entry) setHeight(b []byte) {
+	height := binary.BigEndian.Uint64(b)
+	e.Height = &height
+}
+
+func (e *Entry) decodeValue(value []byte) error {
+	switch e.Kind {
+	case KindSchemaVersion:
+		if len(value) != 4 {
+			return ErrWrongValueLen
+		}
+		e.Value = fmt.Sprint(binary.BigEndian.Uint32(value))
+
+	case KindMigration:
+		if len(value) < 4 {
+			return ErrWrongValueLen
+		}
+		e.Value = fmt.Sprintf("%d:%x", binary.BigEndian.Uint32(value[:4]), value[4:])
+
+	case KindFinishHeight, KindPoolHeight, KindHistoryFloor, KindBlockTime, KindPoolByActivity, KindPoolByTicks:
+		if len(value) != 8 {
+			return ErrWrongValueLen
+		}
+		e.Value = fmt.Sprint(binary.BigEndian.Uint64(value))
+
+	case KindCurrentTick, KindTickSpacing, KindCurrentTickHistory:
+		// the current tick history of a deleted pool is empty from the deletion on
+		if e.Kind == KindCurrentTickHistory && len(value) == 0 {
+			e.Value = "unknown"
+			break
+		}
+		if len(value) != 4 {
+			return ErrWrongValueLen
+		}
+		e.Value = fmt.Sprint(OrderedBytesToInt32(value))
+
+	case KindTickState, KindTickHistory, KindActiveLiquidity:
+		x := new(big.Int)
+		if err := BytesToInt128(value, x); err != nil {
+			return err
+		}
+		e.Value = x.String()
+
+	case KindEvent, KindPoolInfo:
+		e.Value = string(value)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package dbkey
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func uint64Bytes(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func TestDecode(t *testing.T) {
+	addr := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	liquidityNet, err := Int128ToBytes(big.NewInt(-12345))
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		key, value []byte
+		kind       string
+		tick       *int32
+		height     *uint64
+		want       string
+	}{
+		{SchemaVersionKey, binary.BigEndian.AppendUint32(nil, 3), KindSchemaVersion, nil, nil, "3"},
+		{HeightKey, uint64Bytes(45000000), KindFinishHeight, nil, nil, "45000000"},
+		{GetTickStateKey(addr, -60).GetKey(), liquidityNet, KindTickState, ptr(int32(-60)), nil, "-12345"},
+		{concat(PrefixCurrentTick, addr[:]), Int32ToOrderedBytes(-7), KindCurrentTick, nil, nil, "-7"},
+		{concat(PrefixTickSpacing, addr[:]), Int32ToOrderedBytes(60), KindTickSpacing, nil, nil, "60"},
+		{concat(PrefixPoolHeight, addr[:]), uint64Bytes(100), KindPoolHeight, nil, nil, "100"},
+		{concat(PrefixTickHistory, addr[:], Int32ToOrderedBytes(120), uint64Bytes(99)), liquidityNet, KindTickHistory, ptr(int32(120)), ptr(uint64(99)), "-12345"},
+		{concat(PrefixCurrentTickHistory, addr[:], uint64Bytes(99)), Int32ToOrderedBytes(-7), KindCurrentTickHistory, nil, ptr(uint64(99)), "-7"},
+		{concat(PrefixCurrentTickHistory, addr[:], uint64Bytes(100)), nil, KindCurrentTickHistory, nil, ptr(uint64(100)), "unknown"},
+		{concat(PrefixBlockTime, uint64Bytes(99)), uint64Bytes(1748786400), KindBlockTime, nil, ptr(uint64(99)), "1748786400"},
+		{concat(PrefixEvent, addr[:], uint64Bytes(99), binary.BigEndian.AppendUint32(nil, 2)), []byte(`{"type":"swap"}`), KindEvent, nil, ptr(uint64(99)), `{"type":"swap"}`},
+		{concat(PrefixPoolByActivity, uint64Bytes(^uint64(99)), addr[:]), uint64Bytes(99), KindPoolByActivity, nil, ptr(uint64(99)), "99"},
+		{concat(PrefixPoolByTicks, uint64Bytes(^uint64(8)), addr[:]), uint64Bytes(8), KindPoolByTicks, nil, nil, "8"},
+	} {
+		entry := Decode(test.key, test.value)
+		require.Equal(t, test.kind, entry.Kind)
+		require.Empty(t, entry.Error, test.kind)
+		require.Equal(t, test.want, entry.Value, test.kind)
+		require.Equal(t, test.tick, entry.Tick, test.kind)
+		require.Equal(t, test.height, entry.Height, test.kind)
+		if test.kind != KindSchemaVersion && test.kind != KindFinishHeight && test.kind != KindBlockTime {
+			require.Equal(t, addr, *entry.Pool, test.kind)
+		}
+	}
+
+	// malformed entries keep the raw value
+	for _, test := range []struct {
+		key, value []byte
+		kind       string
+	}{
+		{concat(PrefixTickState, []byte{1, 2}), liquidityNet, KindTickState},
+		{GetTickStateKey(addr, 0).GetKey(), []byte{1}, KindTickState},
+		{[]byte("z:"), []byte{1}, KindUnknown},
+		{[]byte{}, nil, KindUnknown},
+	} {
+		entry := Decode(test.key, test.value)
+		require.Equal(t, test.kind, entry.Kind)
+		require.NotEmpty(t, entry.Error)
+		require.Equal(t, hex.EncodeToString(test.value), entry.Value)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
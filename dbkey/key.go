@@ -0,0 +1,116 @@
+// Package dbkey is the key and value layout of the state db, shared by the
+// service and the tools in cmd.
+package dbkey
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Keys start with a 2 byte prefix, pool-scoped keys continue with the pool address:
+//
+//	0:version -> uint32 schema version
+//	0:migration -> uint32 version being migrated to | last rewritten key
+//	1: -> finish height
+//	2: | addr | ordered tick -> liquidityNet
+//	3: | addr -> current tick
+//	4: | addr -> tick spacing
+//	5: | addr -> pool height
+//	6: | addr | ordered tick | height -> liquidityNet after the block
+//	7: | addr | height -> current tick after the block
+//	8: -> history floor
+//	9: | height -> block timestamp
+//	a: | addr | height | log index -> EventRecord json
+//	b: | addr -> active liquidity
+//	c: | addr -> PoolInfo json
+//	d: | ^last updated height | addr -> last updated height
+//	e: | ^tick count | addr -> tick count
+
+var (
+	SchemaVersionKey     = []byte("0:version")
+	MigrationProgressKey = []byte("0:migration")
+	HeightKey            = []byte("1:")
+
+	PrefixTickState          = []byte("2:")
+	PrefixCurrentTick        = []byte("3:")
+	PrefixTickSpacing        = []byte("4:")
+	PrefixPoolHeight         = []byte("5:")
+	PrefixTickHistory        = []byte("6:")
+	PrefixCurrentTickHistory = []byte("7:")
+	HistoryFloorKey          = []byte("8:")
+	PrefixBlockTime          = []byte("9:")
+	PrefixEvent              = []byte("a:")
+	PrefixActiveLiquidity    = []byte("b:")
+	PrefixPoolInfo           = []byte("c:")
+	PrefixPoolByActivity     = []byte("d:")
+	PrefixPoolByTicks        = []byte("e:")
+)
+
+const (
+	PrefixLen                = 2
+	PoolKeyLen               = 22
+	TickStateKeyLen          = 26
+	TickHistoryKeyLen        = 34
+	CurrentTickHistoryKeyLen = 30
+	BlockTimeKeyLen          = 10
+	EventKeyLen              = 34
+	PoolSortKeyLen           = 30
+)
+
+const (
+	MinTick  = int32(-887272) // uniswap v3 core: ./contracts/libraries/TickMath.sol:9: int24 internal constant MIN_TICK = -887272;
+	MaxTick  = int32(887272)
+	MinInt24 = int32(-8388608)
+	MaxInt24 = int32(8388607)
+)
+
+type TickStateKey [TickStateKeyLen]byte
+
+func (k *TickStateKey) setBytes(b []byte) {
+	copy(k[:], b)
+}
+
+func (k TickStateKey) GetAddress() common.Address {
+	return common.BytesToAddress(k[2:22])
+}
+
+func (k TickStateKey) GetTick() int32 {
+	return OrderedBytesToInt32(k[22:26])
+}
+
+func (k TickStateKey) GetKey() []byte {
+	return k[:]
+}
+
+func GetTickStateKey(addr common.Address, tick int32) TickStateKey {
+	var key TickStateKey
+	copy(key[:2], PrefixTickState)
+	copy(key[2:22], addr[:])
+	copy(key[22:], Int32ToOrderedBytes(tick))
+	return key
+}
+
+func BytesToTickStateKey(bytes []byte) TickStateKey {
+	if len(bytes) != TickStateKeyLen {
+		panic("unexpected bytes length") // TODO check
+	}
+
+	var key TickStateKey
+	key.setBytes(bytes)
+	return key
+}
+
+// Int32ToOrderedBytes encodes n so that the bytes sort in the order of n
+func Int32ToOrderedBytes(n int32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(n)+0x80000000)
+	return buf
+}
+
+func OrderedBytesToInt32(bytes []byte) int32 {
+	if len(bytes) != 4 {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(bytes) - 0x80000000)
+}
@@ -0,0 +1,53 @@
+package dbkey
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Value encodings:
+//
+//	liquidityNet: 16 bytes big-endian two's complement int128, the type of liquidityNet in the pool contract
+//	tick, tick spacing: 4 bytes, int32 + 0x80000000 big-endian, the same as the tick in keys
+//	heights, timestamps: 8 bytes big-endian uint64
+
+const (
+	Int128Len = 16
+)
+
+var (
+	ErrInt128Overflow = errors.New("value overflows int128")
+	ErrWrongValueLen  = errors.New("wrong value length")
+
+	Int128Min = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 127))
+	Int128Max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(1))
+	two128    = new(big.Int).Lsh(big.NewInt(1), 128)
+)
+
+// Int128ToBytes fails with ErrInt128Overflow for values outside int128
+func Int128ToBytes(x *big.Int) ([]byte, error) {
+	if x.Cmp(Int128Min) < 0 || x.Cmp(Int128Max) > 0 {
+		return nil, ErrInt128Overflow
+	}
+
+	v := x
+	if x.Sign() < 0 {
+		v = new(big.Int).Add(x, two128)
+	}
+
+	buf := make([]byte, Int128Len)
+	v.FillBytes(buf)
+	return buf, nil
+}
+
+func BytesToInt128(data []byte, x *big.Int) error {
+	if len(data) != Int128Len {
+		return ErrWrongValueLen
+	}
+
+	x.SetBytes(data)
+	if data[0]&0x80 != 0 {
+		x.Sub(x, two128)
+	}
+	return nil
+}
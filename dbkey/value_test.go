@@ -0,0 +1,28 @@
+package dbkey
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInt128ToBytes(t *testing.T) {
+	for _, v := range []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(-1), big.NewInt(-123456789), Int128Min, Int128Max} {
+		bytes, err := Int128ToBytes(v)
+		require.NoError(t, err)
+		require.Len(t, bytes, Int128Len)
+
+		x := new(big.Int)
+		require.NoError(t, BytesToInt128(bytes, x))
+		require.Equal(t, 0, v.Cmp(x), "want %s, got %s", v, x)
+	}
+
+	_, err := Int128ToBytes(new(big.Int).Add(Int128Max, big.NewInt(1)))
+	require.ErrorIs(t, err, ErrInt128Overflow)
+
+	_, err = Int128ToBytes(new(big.Int).Sub(Int128Min, big.NewInt(1)))
+	require.ErrorIs(t, err, ErrInt128Overflow)
+
+	require.ErrorIs(t, BytesToInt128([]byte{1}, new(big.Int)), ErrWrongValueLen)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// Every applied event is kept for analysis:
+//
+//	a: | addr | height | log index -> EventRecord json
+
+var (
+	KeyPrefixEvent = dbkey.PrefixEvent
+)
+
+const (
+	EventKeyLen = dbkey.EventKeyLen
+)
+
+var (
+	EventTypeNames = map[int]string{
+		EventTypeMint:       "mint",
+		EventTypeBurn:       "burn",
+		EventTypeSwap:       "swap",
+		EventTypeInitialize: "initialize",
+	}
+
+	ErrWrongCursor = errors.New("wrong cursor")
+)
+
+type EventRecord struct {
+	Height       uint64         `json:"height"`
+	TxHash       common.Hash    `json:"txHash"`
+	LogIndex     uint32         `json:"logIndex"`
+	Type         string         `json:"type"`
+	Owner        common.Address `json:"owner,omitempty"`
+	TickLower    *int32         `json:"tickLower,omitempty"`
+	TickUpper    *int32         `json:"tickUpper,omitempty"`
+	Amount       *big.Int       `json:"amount,omitempty"`
+	Tick         *int32         `json:"tick,omitempty"`
+	SqrtPriceX96 *big.Int       `json:"sqrtPriceX96,omitempty"`
+	Liquidity    *big.Int       `json:"liquidity,omitempty"`
+}
+
+func tickPtr(tick *big.Int) *int32 {
+	if tick == nil {
+		return nil
+	}
+	t := int32(tick.Int64())
+	return &t
+}
+
+func NewEventRecord(height uint64, event *Event) *EventRecord {
+	record := &EventRecord{
+		Height:       height,
+		TxHash:       event.TxHash,
+		LogIndex:     uint32(event.LogIndex),
+		Type:         EventTypeNames[event.Type],
+		TickLower:    tickPtr(event.TickLower),
+		TickUpper:    tickPtr(event.TickUpper),
+		Amount:       event.Amount,
+		Tick:         tickPtr(event.Tick),
+		SqrtPriceX96: event.SqrtPriceX96,
+		Liquidity:    event.Liquidity,
+	}
+	if event.Type == EventTypeMint || event.Type == EventTypeBurn {
+		record.Owner = event.Owner
+	}
+	return record
+}
+
+// EventQuery selects events of one pool in [FromHeight, ToHeight], Type is empty for all types
+type EventQuery struct {
+	Address    common.Address
+	FromHeight uint64
+	ToHeight   uint64
+	Type       string
+	Cursor     string
+	Limit      int
+}
+
+// EventPage is one page of events, NextCursor is empty on the last page
+type EventPage struct {
+	Events     []*EventRecord `json:"events"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+func makeEventKey(addr common.Address, height uint64, logIndex uint32) [EventKeyLen]byte {
+	var key [EventKeyLen]byte
+	copy(key[:2], KeyPrefixEvent)
+	copy(key[2:22], addr[:])
+	copy(key[22:30], uint64ToBytes(height))
+	copy(key[30:34], uint32ToBytes(logIndex))
+	return key
+}
+
+func uint32ToBytes(n uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	return buf
+}
+
+// the cursor is the position of the next event: <height>-<log index>
+func formatCursor(height uint64, logIndex uint32) string {
+	return fmt.Sprintf("%d-%d", height, logIndex)
+}
+
+func parseCursor(cursor string) (uint64, uint32, error) {
+	heightStr, logIndexStr, ok := strings.Cut(cursor, "-")
+	if !ok {
+		return 0, 0, ErrWrongCursor
+	}
+
+	height, err := strconv.ParseUint(heightStr, 10, 64)
+	if err != nil {
+		return 0, 0, ErrWrongCursor
+	}
+
+	logIndex, err := strconv.ParseUint(logIndexStr, 10, 32)
+	if err != nil {
+		return 0, 0, ErrWrongCursor
+	}
+
+	return height, uint32(logIndex), nil
+}
+
+func (r *rocksDBWrap) AddEvents(addr common.Address, records []*EventRecord) error {
+	batch := r.db.NewBatch()
+	defer batch.Destroy()
+
+	for _, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		key := makeEventKey(addr, record.Height, record.LogIndex)
+		batch.Put(key[:], value)
+	}
+
+	return r.db.WriteBatch(batch)
+}
+
+func (r *rocksDBWrap) GetEvents(query *EventQuery) (*EventPage, error) {
+	// an empty range, e.g. from_at and to_at between two blocks
+	if query.FromHeight > query.ToHeight {
+		return &EventPage{Events: make([]*EventRecord, 0)}, nil
+	}
+
+	from := makeEventKey(query.Address, query.FromHeight, 0)
+	if query.Cursor != "" {
+		height, logIndex, err := parseCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		from = makeEventKey(query.Address, height, logIndex)
+	}
+	to := makeEventKey(query.Address, query.ToHeight, math.MaxUint32)
+
+	page := &EventPage{Events: make([]*EventRecord, 0)}
+	err := r.db.Scan(from[:], to[:], func(key, value []byte) error {
+		record := &EventRecord{}
+		if err := json.Unmarshal(value, record); err != nil {
+			return err
+		}
+
+		if query.Type != "" && record.Type != query.Type {
+			return nil
+		}
+
+		if len(page.Events) == query.Limit {
+			page.NextCursor = formatCursor(record.Height, record.LogIndex)
+			return ErrStopScan
+		}
+		page.Events = append(page.Events, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
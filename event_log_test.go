@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func Test_AddEvents_GetEvents(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	addr := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	other := common.HexToAddress("0x7000000000000000000000000000000000000008")
+
+	mint := &Event{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(-10), TickUpper: big.NewInt(10), Amount: big.NewInt(5), Owner: other}
+	swap := &Event{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(-3), SqrtPriceX96: big.NewInt(79228162514264337), Liquidity: big.NewInt(5)}
+	var records []*EventRecord
+	for height := uint64(100); height < 110; height++ {
+		mint.LogIndex, swap.LogIndex = 1, 2
+		records = append(records, NewEventRecord(height, mint), NewEventRecord(height, swap))
+	}
+	if err := repo.AddEvents(addr, records); err != nil {
+		t.Fatalf("AddEvents failed: %v", err)
+	}
+	if err := repo.AddEvents(other, records[:1]); err != nil {
+		t.Fatalf("AddEvents failed: %v", err)
+	}
+
+	// all swaps of [102, 107] in pages of 4
+	query := &EventQuery{Address: addr, FromHeight: 102, ToHeight: 107, Type: "swap", Limit: 4}
+	var got []*EventRecord
+	pages := 0
+	for {
+		page, err := repo.GetEvents(query)
+		if err != nil {
+			t.Fatalf("GetEvents failed: %v", err)
+		}
+		got = append(got, page.Events...)
+		pages++
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if pages != 2 || len(got) != 6 {
+		t.Fatalf("GetEvents: want 6 events in 2 pages, got %d in %d", len(got), pages)
+	}
+	for i, record := range got {
+		if record.Height != 102+uint64(i) || record.Type != "swap" || *record.Tick != -3 || record.Liquidity.Int64() != 5 {
+			t.Fatalf("GetEvents: unexpected record %+v", record)
+		}
+	}
+
+	page, err := repo.GetEvents(&EventQuery{Address: addr, FromHeight: 0, ToHeight: math.MaxUint64, Type: "mint", Limit: 100})
+	if err != nil || len(page.Events) != 10 || page.NextCursor != "" {
+		t.Fatalf("GetEvents mint: got %v, %v", page, err)
+	}
+	if record := page.Events[0]; *record.TickLower != -10 || *record.TickUpper != 10 || record.Owner != other || record.Amount.Int64() != 5 {
+		t.Fatalf("GetEvents mint: unexpected record %+v", record)
+	}
+
+	// from_at and to_at between two blocks
+	page, err = repo.GetEvents(&EventQuery{Address: addr, FromHeight: 105, ToHeight: 104, Limit: 100})
+	if err != nil || len(page.Events) != 0 || page.NextCursor != "" {
+		t.Fatalf("GetEvents empty range: got %v, %v", page, err)
+	}
+
+	if _, err = repo.GetEvents(&EventQuery{Address: addr, Cursor: "bad", Limit: 1}); err != ErrWrongCursor {
+		t.Fatalf("GetEvents: want ErrWrongCursor, got %v", err)
+	}
+}
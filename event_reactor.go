@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"go.uber.org/zap"
 	"math/big"
 	"sync"
+	"sync/atomic"
 )
 
 type EventReactor interface {
@@ -18,8 +20,26 @@ type eventReactor struct {
 	wg              *sync.WaitGroup
 	db              DB
 	poolStateGetter PoolStateGetter
+	conf            *EventReactorConf
+	pruning         atomic.Bool
+
+	// mu is held for a block and for each step of the pool pruner, so a pool is
+	// never pruned between reading its state and writing the block
+	mu          sync.Mutex
+	closed      bool
+	poolPruner  *PoolPruner // nil disables pool pruning
+	poolPruning atomic.Bool
+
+	// background runs the pruners, shutdown cancels ctx and waits for them before closing the db
+	background sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
+var (
+	ErrLiquidityMismatch = errors.New("liquidity mismatch")
+)
+
 func IsIgnorantError(err error) bool {
 	if errors.Is(err, ErrPairNotFound) ||
 		errors.Is(err, ErrPairFiltered) ||
@@ -32,7 +52,22 @@ func IsIgnorantError(err error) bool {
 func (r *eventReactor) ReactBlockEvent(blockEvent *BlockEvent) error {
 	Log.Debug("ReactBlockEvent begin", zap.Any("height", blockEvent.Height))
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.bootstrapPools(blockEvent); err != nil {
+		return err
+	}
+
+	w := NewBlockWrite(r.db, blockEvent.Height, blockEvent.Timestamp)
+
 	for _, event := range blockEvent.Events {
+		// a quarantined pool skips the rest of the block, it is bootstrapped again on a later event
+		if w.IsDeleted(event.Address) {
+			continue
+		}
+
+		// the committed height, so every event of a pool in this block is applied
 		height, err := r.db.GetHeight(event.Address)
 		if err != nil {
 			return err
@@ -54,15 +89,121 @@ func (r *eventReactor) ReactBlockEvent(blockEvent *BlockEvent) error {
 			continue
 		}
 
-		if err = r.reactEvent(event); err != nil {
-			return err
+		if err = r.reactEvent(w, event); err != nil {
+			if !errors.Is(err, ErrLiquidityMismatch) {
+				return err
+			}
+
+			// quarantine: the broken state is deleted with the block
+			Log.Warn("pool quarantined", zap.String("addr", event.Address.String()), zap.Uint64("height", blockEvent.Height), zap.Error(err))
+			w.Delete(event.Address)
+			continue
 		}
 
-		r.db.SetHeight(event.Address, blockEvent.Height)
+		w.CountEvent(event.Address, EventTypeNames[event.Type])
+		if r.conf.StoreEvents {
+			w.AddEvent(event.Address, NewEventRecord(blockEvent.Height, event))
+		}
 	}
 
 	Log.Info("ReactBlockEvent end", zap.Any("height", blockEvent.Height))
-	return r.db.SetFinishHeight(blockEvent.Height)
+	if err := r.db.WriteBlock(w); err != nil {
+		return err
+	}
+
+	r.pruneHistory(blockEvent.Height)
+	r.prunePools(blockEvent.Height)
+	return nil
+}
+
+// pruneHistory drops the tick history older than the retention in background, one run at a time
+func (r *eventReactor) pruneHistory(height uint64) {
+	retention := r.conf.HistoryRetention
+	if retention == 0 || height <= retention || r.conf.HistoryPruneInterval == 0 || height%r.conf.HistoryPruneInterval != 0 {
+		return
+	}
+
+	if !r.pruning.CompareAndSwap(false, true) {
+		return
+	}
+
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		defer r.pruning.Store(false)
+
+		if err := r.db.PruneHistory(r.ctx, height-retention); err != nil {
+			Log.Error("PruneHistory error", zap.Error(err), zap.Uint64("before", height-retention))
+		}
+	}()
+}
+
+// prunePools removes the pools matching the pruning policy in background, one run at a time.
+// Each step takes the reactor lock for one page of pools, blocks are applied in between.
+func (r *eventReactor) prunePools(height uint64) {
+	if r.poolPruner == nil || !r.poolPruner.Due(height) {
+		return
+	}
+
+	if !r.poolPruning.CompareAndSwap(false, true) {
+		return
+	}
+
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		defer r.poolPruning.Store(false)
+
+		cursor, total := "", 0
+		for {
+			r.mu.Lock()
+			if r.closed {
+				r.mu.Unlock()
+				return
+			}
+			next, pruned, err := r.poolPruner.Step(cursor, height)
+			r.mu.Unlock()
+
+			if err != nil {
+				Log.Error("prune pools error", zap.Error(err), zap.Uint64("height", height))
+				return
+			}
+			total += pruned
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		Log.Info("pools pruned", zap.Uint64("height", height), zap.Int("pools", total))
+	}()
+}
+
+// bootstrapPools loads all unknown pools of the block in one batch
+func (r *eventReactor) bootstrapPools(blockEvent *BlockEvent) error {
+	seen := make(map[common.Address]struct{})
+	var addrs []common.Address
+	for _, event := range blockEvent.Events {
+		if _, ok := seen[event.Address]; ok {
+			continue
+		}
+		seen[event.Address] = struct{}{}
+
+		height, err := r.db.GetHeight(event.Address)
+		if err != nil {
+			return err
+		}
+
+		if height == 0 {
+			addrs = append(addrs, event.Address)
+		}
+	}
+
+	if len(addrs) <= 1 {
+		return nil
+	}
+
+	_, err := r.poolStateGetter.GetPoolStates(addrs)
+	return err
 }
 
 func (r *eventReactor) PutInput(blockEvent *BlockEvent) {
@@ -78,34 +219,84 @@ func (r *eventReactor) FinInput() {
 }
 
 func (r *eventReactor) shutdown() {
+	// a running pool pruner stops before its next step, history pruning before its next pool
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.cancel()
+	r.background.Wait()
+
 	r.db.Close()
 	r.wg.Done()
 }
 
-func NewEventReactor(wg *sync.WaitGroup, db DB, poolStateGetter PoolStateGetter) EventReactor {
+func NewEventReactor(wg *sync.WaitGroup, db DB, poolStateGetter PoolStateGetter, poolPruner *PoolPruner, conf *EventReactorConf) EventReactor {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &eventReactor{
 		wg:              wg,
 		db:              db,
 		poolStateGetter: poolStateGetter,
+		conf:            conf,
+		poolPruner:      poolPruner,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// TickStore is the part of DB that applying pool events needs
+type TickStore interface {
+	GetTickState(addr common.Address, tick int32) (*TickState, error)
+	SetTickState(addr common.Address, tickState *TickState) error
+	SetCurrentTick(addr common.Address, tick int32) error
+}
+
+func (r *eventReactor) reactEvent(w *BlockWrite, event *Event) error {
+	// checked against the state before the swap moves the current tick
+	if event.Type == EventTypeSwap && r.conf.CheckLiquidity {
+		if err := verifyLiquidity(w, event.Address, int32(event.Tick.Int64()), event.Liquidity); err != nil {
+			return err
+		}
+	}
+
+	if err := ApplyEvent(w, event); err != nil {
+		return err
 	}
+
+	return updateActiveLiquidity(w, event)
 }
 
-func (r *eventReactor) reactEvent(event *Event) error {
+func ApplyEvent(store TickStore, event *Event) error {
 	switch event.Type {
 	case EventTypeMint:
-		r.reactTick(event.Address, int32(event.TickLower.Int64()), event.Amount)
-		r.reactTick(event.Address, int32(event.TickUpper.Int64()), new(big.Int).Neg(event.Amount))
+		if err := applyTick(store, event.Address, int32(event.TickLower.Int64()), event.Amount); err != nil {
+			return err
+		}
+		if err := applyTick(store, event.Address, int32(event.TickUpper.Int64()), new(big.Int).Neg(event.Amount)); err != nil {
+			return err
+		}
 		Log.Debug("Mint Event", zap.String("addr", event.Address.String()))
 
 	case EventTypeBurn:
-		r.reactTick(event.Address, int32(event.TickLower.Int64()), new(big.Int).Neg(event.Amount))
-		r.reactTick(event.Address, int32(event.TickUpper.Int64()), event.Amount)
+		if err := applyTick(store, event.Address, int32(event.TickLower.Int64()), new(big.Int).Neg(event.Amount)); err != nil {
+			return err
+		}
+		if err := applyTick(store, event.Address, int32(event.TickUpper.Int64()), event.Amount); err != nil {
+			return err
+		}
 		Log.Debug("Burn Event", zap.String("addr", event.Address.String()))
 
 	case EventTypeSwap:
-		r.db.SetCurrentTick(event.Address, int32(event.Tick.Int64()))
+		if err := store.SetCurrentTick(event.Address, int32(event.Tick.Int64())); err != nil {
+			return err
+		}
 		Log.Debug("Swap Event", zap.String("addr", event.Address.String()))
 
+	case EventTypeInitialize:
+		if err := store.SetCurrentTick(event.Address, int32(event.Tick.Int64())); err != nil {
+			return err
+		}
+		Log.Debug("Initialize Event", zap.String("addr", event.Address.String()))
+
 	default:
 		panic(fmt.Sprintf("wrong event: %v", event.Type))
 	}
@@ -113,21 +304,104 @@ func (r *eventReactor) reactEvent(event *Event) error {
 	return nil
 }
 
-func (r *eventReactor) reactTick(addr common.Address, tick int32, amount *big.Int) error {
-	tickState := r.getOrNewTickState(addr, tick)
-	tickState.AddLiquidity(amount)
-	return r.db.SetTickState(addr, tickState)
+// updateActiveLiquidity keeps the liquidity checkpoint in step with the pool contract
+func updateActiveLiquidity(w *BlockWrite, event *Event) error {
+	switch event.Type {
+	case EventTypeSwap:
+		if event.Liquidity == nil {
+			return nil
+		}
+		return w.SetActiveLiquidity(event.Address, event.Liquidity)
+
+	case EventTypeInitialize:
+		return w.SetActiveLiquidity(event.Address, big.NewInt(0))
+
+	case EventTypeMint, EventTypeBurn:
+		liquidity, err := w.GetActiveLiquidity(event.Address)
+		if err != nil || liquidity == nil {
+			return err
+		}
+
+		currentTick, err := w.GetCurrentTick(event.Address)
+		if err != nil {
+			return err
+		}
+
+		// same as the pool contract: in range when tickLower <= tick < tickUpper
+		if currentTick < int32(event.TickLower.Int64()) || currentTick >= int32(event.TickUpper.Int64()) {
+			return nil
+		}
+
+		if event.Type == EventTypeMint {
+			liquidity.Add(liquidity, event.Amount)
+		} else {
+			liquidity.Sub(liquidity, event.Amount)
+		}
+		return w.SetActiveLiquidity(event.Address, liquidity)
+	}
+
+	return nil
+}
+
+// verifyLiquidity checks the in-range liquidity carried by a Swap event against the
+// active liquidity checkpoint moved over the ticks the swap crosses, so a swap reads
+// the crossed ticks only. It runs before the swap is applied.
+func verifyLiquidity(w *BlockWrite, addr common.Address, tick int32, liquidity *big.Int) error {
+	if liquidity == nil {
+		return nil
+	}
+
+	expected, err := w.GetActiveLiquidity(addr)
+	if err != nil {
+		return err
+	}
+
+	if expected == nil {
+		// no checkpoint yet, sum the tick map once
+		tickStates, err := w.GetTickStates(addr)
+		if err != nil {
+			return err
+		}
+		expected = CalcActiveLiquidity(tickStates, tick)
+	} else {
+		currentTick, err := w.GetCurrentTick(addr)
+		if err != nil {
+			return err
+		}
+
+		// active liquidity is the sum of liquidityNet of the ticks <= the current tick
+		if tick > currentTick {
+			crossed, err := w.sumLiquidityNetBetween(addr, currentTick+1, tick)
+			if err != nil {
+				return err
+			}
+			expected.Add(expected, crossed)
+		} else if tick < currentTick {
+			crossed, err := w.sumLiquidityNetBetween(addr, tick+1, currentTick)
+			if err != nil {
+				return err
+			}
+			expected.Sub(expected, crossed)
+		}
+	}
+
+	if expected.Cmp(liquidity) != 0 {
+		return fmt.Errorf("%w: tick=%d, event=%s, stored=%s", ErrLiquidityMismatch, tick, liquidity, expected)
+	}
+
+	return nil
 }
 
-func (r *eventReactor) getOrNewTickState(addr common.Address, tick int32) *TickState {
-	tickState, err := r.db.GetTickState(addr, tick)
+func applyTick(store TickStore, addr common.Address, tick int32, amount *big.Int) error {
+	tickState, err := store.GetTickState(addr, tick)
 	if err != nil {
-		panic(fmt.Sprintf("GetTickState err: addr=%v,tick=%d, err=%v", addr.String(), tick, err))
+		return fmt.Errorf("GetTickState err: addr=%v, tick=%d, err=%w", addr.String(), tick, err)
 	}
 
 	if tickState == nil {
-		return NewTickState(tick)
+		tickState = NewTickState(tick)
 	}
 
-	return tickState
+	tickState.AddLiquidity(amount)
+	return store.SetTickState(addr, tickState)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReactBlockEvent_LiquidityMismatch(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	addr := common.HexToAddress("0xa00000000000000000000000000000000000000a")
+	require.NoError(t, db.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(100),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: -60, LiquidityNet: big.NewInt(1000)},
+			{Tick: 60, LiquidityNet: big.NewInt(-1000)},
+		},
+	}))
+
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, nil, &EventReactorConf{CheckLiquidity: true})
+
+	swap := &Event{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(10), Liquidity: big.NewInt(1000)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 101, Events: []*Event{swap}}))
+	height, err := db.GetHeight(addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), height)
+
+	swap = &Event{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(70), Liquidity: big.NewInt(1000)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 102, Events: []*Event{swap}}))
+	poolState, err := db.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Nil(t, poolState, "mismatched pool should be quarantined")
+
+	// the history up to the quarantine stays
+	poolState, err = db.GetPoolStateAt(addr, 101)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), poolState.Global.Tick.Int64())
+	require.Len(t, poolState.TickStates, 2)
+	poolState, err = db.GetPoolStateAt(addr, 102)
+	require.NoError(t, err)
+	require.Nil(t, poolState)
+}
+
+func TestReactBlockEvent_WholeBlock(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	addr := common.HexToAddress("0xb00000000000000000000000000000000000000b")
+	require.NoError(t, db.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(100),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: -60, LiquidityNet: big.NewInt(1000)},
+			{Tick: 60, LiquidityNet: big.NewInt(-1000)},
+		},
+	}))
+	require.NoError(t, db.SetFinishHeight(100))
+
+	before, release := db.(*rocksDBWrap).view()
+	defer release()
+
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, nil, &EventReactorConf{CheckLiquidity: true})
+
+	// both events of the pool in the block are applied
+	mint := &Event{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(-120), TickUpper: big.NewInt(120), Amount: big.NewInt(500)}
+	swap := &Event{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(70), Liquidity: big.NewInt(500)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 101, Events: []*Event{mint, swap}}))
+
+	poolState, err := db.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), poolState.Global.Height.Uint64())
+	require.Equal(t, int64(70), poolState.Global.Tick.Int64())
+	require.Equal(t, map[int32]int64{-120: 500, -60: 1000, 60: -1000, 120: -500}, tickStatesToMap(poolState.TickStates))
+
+	// a snapshot taken before the block sees none of it
+	poolState, err = before.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), poolState.Global.Height.Uint64())
+	require.Equal(t, int64(0), poolState.Global.Tick.Int64())
+	require.Equal(t, map[int32]int64{-60: 1000, 60: -1000}, tickStatesToMap(poolState.TickStates))
+}
+
+// tickMapCountingDB counts the reads of whole tick maps
+type tickMapCountingDB struct {
+	DB
+	tickMapReads int
+}
+
+func (d *tickMapCountingDB) GetTickStates(addr common.Address) ([]*TickState, error) {
+	d.tickMapReads++
+	return d.DB.GetTickStates(addr)
+}
+
+func TestReactBlockEvent_VerifyLiquidityCrossedTicks(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	db := &tickMapCountingDB{DB: repo}
+
+	addr := common.HexToAddress("0xe00000000000000000000000000000000000000e")
+	other := common.HexToAddress("0xf00000000000000000000000000000000000000f")
+	for _, a := range []common.Address{addr, other} {
+		require.NoError(t, db.SetPoolState(a, &PoolState{
+			Global: &PoolGlobalState{
+				Height:      big.NewInt(100),
+				TickSpacing: big.NewInt(60),
+				Tick:        big.NewInt(0),
+			},
+			TickStates: []*TickState{
+				{Tick: -120, LiquidityNet: big.NewInt(300)},
+				{Tick: -60, LiquidityNet: big.NewInt(1000)},
+				{Tick: 60, LiquidityNet: big.NewInt(-1000)},
+				{Tick: 120, LiquidityNet: big.NewInt(-300)},
+			},
+		}))
+	}
+
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, nil, &EventReactorConf{CheckLiquidity: true})
+
+	// up over 60, a pending mint above, back down over -60 and -120
+	events := []*Event{
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(70), Liquidity: big.NewInt(300)},
+		{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(60), TickUpper: big.NewInt(180), Amount: big.NewInt(50)},
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(130), Liquidity: big.NewInt(50)},
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(-130), Liquidity: big.NewInt(0)},
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(-60), Liquidity: big.NewInt(1300)},
+	}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 101, Events: events}))
+	require.Zero(t, db.tickMapReads, "swaps are checked on the crossed ticks")
+
+	poolState, err := db.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), poolState.Global.Height.Uint64())
+	require.Equal(t, int64(-60), poolState.Global.Tick.Int64())
+
+	// the mismatched pool is deleted in the block batch, its later events are skipped
+	events = []*Event{
+		{Address: other, Type: EventTypeSwap, Tick: big.NewInt(70), Liquidity: big.NewInt(999)},
+		{Address: other, Type: EventTypeSwap, Tick: big.NewInt(0), Liquidity: big.NewInt(1300)},
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(0), Liquidity: big.NewInt(1300)},
+	}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 102, Events: events}))
+
+	poolState, err = db.GetPoolState(other)
+	require.NoError(t, err)
+	require.Nil(t, poolState)
+
+	poolState, err = db.GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), poolState.Global.Tick.Int64())
+
+	finishHeight, err := db.GetFinishHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(102), finishHeight)
+}
+
+// blockingPruneDB holds PruneHistory until it is cancelled
+type blockingPruneDB struct {
+	DB
+	started          chan struct{}
+	pruneReturned    atomic.Bool
+	closedAfterPrune bool
+}
+
+func (d *blockingPruneDB) PruneHistory(ctx context.Context, before uint64) error {
+	close(d.started)
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	d.pruneReturned.Store(true)
+	return ctx.Err()
+}
+
+func (d *blockingPruneDB) Close() {
+	d.closedAfterPrune = d.pruneReturned.Load()
+	d.DB.Close()
+}
+
+func TestEventReactor_ShutdownWaitsForPruning(t *testing.T) {
+	db := &blockingPruneDB{DB: newTestRepo(t), started: make(chan struct{})}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	reactor := NewEventReactor(wg, db, nil, nil, &EventReactorConf{HistoryRetention: 10, HistoryPruneInterval: 10})
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 20}))
+	<-db.started
+
+	reactor.FinInput()
+	wg.Wait()
+	require.True(t, db.closedAfterPrune, "the db is closed after the running prune stopped")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math/big"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// Value encodings are in dbkey/value.go
+
+const (
+	Int128Len = dbkey.Int128Len
+)
+
+var (
+	ErrInt128Overflow = dbkey.ErrInt128Overflow
+	ErrWrongValueLen  = dbkey.ErrWrongValueLen
+)
+
+func int128ToBytes(x *big.Int) ([]byte, error) {
+	return dbkey.Int128ToBytes(x)
+}
+
+func bytesToInt128(data []byte, x *big.Int) error {
+	return dbkey.BytesToInt128(data, x)
+}
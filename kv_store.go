@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type EntryK interface {
+	K() []byte
+}
+
+type EntryV interface {
+	V() []byte
+}
+type KVEntry interface {
+	EntryK
+	EntryV
+}
+
+type bytesEntry struct {
+	key []byte
+	val []byte
+}
+
+func (e *bytesEntry) K() []byte { return e.key }
+func (e *bytesEntry) V() []byte { return e.val }
+
+type RocksDBOptions struct {
+	EnableLog            bool
+	BlockCacheSize       uint64
+	WriteBufferSize      uint64
+	MaxWriteBufferNumber int
+}
+
+// Keys are routed to column families by their 2 byte prefix. Pool-scoped keys
+// start with prefix + pool address, the first 22 bytes are their bloom prefix.
+const (
+	CFDefault = "default" // global metadata
+	CFTick    = "tick"    // tick states and tick history
+	CFPool    = "pool"    // per-pool metadata and events
+
+	PoolKeyPrefixLen = 22
+
+	bloomBitsPerKey              = 10
+	memtablePrefixBloomSizeRatio = 0.1
+)
+
+var (
+	ColumnFamilies = []string{CFDefault, CFTick, CFPool}
+
+	cfOfPrefix = map[string]string{
+		string(KeyPrefixTickState):          CFTick,
+		string(KeyPrefixTickHistory):        CFTick,
+		string(KeyPrefixCurrentTick):        CFPool,
+		string(KeyPrefixTickSpacing):        CFPool,
+		string(KeyPrefixPoolHeight):         CFPool,
+		string(KeyPrefixCurrentTickHistory): CFPool,
+		string(KeyPrefixEvent):              CFPool,
+		string(KeyPrefixActiveLiquidity):    CFPool,
+		string(KeyPrefixPoolInfo):           CFPool,
+	}
+)
+
+// ColumnFamilyOf returns the column family of a key
+func ColumnFamilyOf(key []byte) string {
+	if len(key) >= 2 {
+		if cf, ok := cfOfPrefix[string(key[:2])]; ok {
+			return cf
+		}
+	}
+	return CFDefault
+}
+
+// ErrStopScan stops a Scan early without an error
+var ErrStopScan = errors.New("stop scan")
+
+// KVStore is the ordered key-value storage the DB is built on. Keys and values
+// keep the layout of db_wrap.go whatever the backend.
+type KVStore interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	// Scan calls fn for every entry of [from, to] in key order on one snapshot, see RocksDB.Scan
+	Scan(from, to []byte, fn func(key, value []byte) error) error
+	GetPrev(lowerBound, to []byte) (KVEntry, error)
+	GetNext(from, upperBound []byte) (KVEntry, error)
+	NewBatch() KVBatch
+	WriteBatch(batch KVBatch) error
+	// Snapshot returns a read view pinned at the current state, a view returns itself
+	Snapshot() (KVStore, func())
+	Close()
+}
+
+// KVBatch is a set of writes applied atomically by KVStore.WriteBatch
+type KVBatch interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+	// DeleteRange deletes [from, to)
+	DeleteRange(from, to []byte)
+	Destroy()
+}
+
+const (
+	BackendRocksDB = "rocksdb"
+	BackendLevelDB = "leveldb"
+	BackendMemory  = "memory" // nothing is written to disk, for tests and throwaway runs
+)
+
+var (
+	ErrUnknownBackend = errors.New("unknown db backend")
+)
+
+// OpenKVStore opens the store of the configured backend at name, the memory
+// backend ignores name
+func OpenKVStore(backend string, name string, optsConf *RocksDBOptions) (KVStore, error) {
+	switch backend {
+	case "":
+		return OpenKVStore(DefaultBackend, name, optsConf)
+	case BackendRocksDB:
+		return NewRocksDB(name, optsConf)
+	case BackendLevelDB:
+		return NewLevelDB(name, optsConf)
+	case BackendMemory:
+		return NewMemDB(optsConf)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backend)
+	}
+}
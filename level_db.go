@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDB is the pure-Go KVStore. All keys share one keyspace, the column
+// families of RocksDB only change where keys are stored, not their order.
+// Checkpoints, backups and secondary instances need RocksDB.
+type LevelDB struct {
+	db *leveldb.DB
+
+	// snapshot is set on the views returned by Snapshot
+	snapshot *leveldb.Snapshot
+}
+
+func newLevelDBOptions(optsConf *RocksDBOptions) *opt.Options {
+	return &opt.Options{
+		BlockCacheCapacity: int(optsConf.BlockCacheSize),
+		WriteBuffer:        int(optsConf.WriteBufferSize),
+		Filter:             filter.NewBloomFilter(bloomBitsPerKey),
+	}
+}
+
+func NewLevelDB(name string, optsConf *RocksDBOptions) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(name, newLevelDBOptions(optsConf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LevelDB: %v", err)
+	}
+
+	return &LevelDB{db: db}, nil
+}
+
+// NewMemDB returns a LevelDB kept entirely in memory, its content is lost on Close.
+// It reuses goleveldb on a memory storage on purpose: snapshots, batches and
+// iterators behave exactly as on disk and there is no second store to maintain.
+func NewMemDB(optsConf *RocksDBOptions) (*LevelDB, error) {
+	db, err := leveldb.Open(storage.NewMemStorage(), newLevelDBOptions(optsConf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open in-memory LevelDB: %v", err)
+	}
+
+	return &LevelDB{db: db}, nil
+}
+
+func (l *LevelDB) Close() {
+	if l.snapshot != nil {
+		return
+	}
+	l.db.Close()
+}
+
+func (l *LevelDB) Snapshot() (KVStore, func()) {
+	if l.snapshot != nil {
+		return l, func() {}
+	}
+
+	snapshot, err := l.db.GetSnapshot()
+	if err != nil {
+		// the db is closed, reads through l fail the same way
+		return l, func() {}
+	}
+
+	return &LevelDB{db: l.db, snapshot: snapshot}, snapshot.Release
+}
+
+func (l *LevelDB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	var err error
+	if l.snapshot != nil {
+		value, err = l.snapshot.Get(key, nil)
+	} else {
+		value, err = l.db.Get(key, nil)
+	}
+
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (l *LevelDB) Set(key, value []byte) error {
+	return l.db.Put(key, value, nil)
+}
+
+// newIterator iterates [from, to], leveldb iterators read from an implicit snapshot
+func (l *LevelDB) newIterator(from, to []byte) iterator.Iterator {
+	r := &util.Range{Start: from, Limit: append(append([]byte{}, to...), 0)}
+	if l.snapshot != nil {
+		return l.snapshot.NewIterator(r, nil)
+	}
+	return l.db.NewIterator(r, nil)
+}
+
+func (l *LevelDB) Scan(from, to []byte, fn func(key, value []byte) error) error {
+	it := l.newIterator(from, to)
+	defer it.Release()
+
+	for it.Next() {
+		err := fn(it.Key(), it.Value())
+		if errors.Is(err, ErrStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return it.Error()
+}
+
+func (l *LevelDB) GetPrev(lowerBound, to []byte) (KVEntry, error) {
+	it := l.newIterator(lowerBound, to)
+	defer it.Release()
+
+	if !it.Last() {
+		return nil, it.Error()
+	}
+	return &bytesEntry{key: append([]byte{}, it.Key()...), val: append([]byte{}, it.Value()...)}, nil
+}
+
+func (l *LevelDB) GetNext(from, upperBound []byte) (KVEntry, error) {
+	it := l.newIterator(from, upperBound)
+	defer it.Release()
+
+	if !it.First() {
+		return nil, it.Error()
+	}
+	return &bytesEntry{key: append([]byte{}, it.Key()...), val: append([]byte{}, it.Value()...)}, nil
+}
+
+// levelBatch resolves DeleteRange into single deletes of the keys present when it is called
+type levelBatch struct {
+	l     *LevelDB
+	batch *leveldb.Batch
+}
+
+func (l *LevelDB) NewBatch() KVBatch {
+	return &levelBatch{l: l, batch: new(leveldb.Batch)}
+}
+
+func (b *levelBatch) Put(key, value []byte) {
+	b.batch.Put(key, value)
+}
+
+func (b *levelBatch) Delete(key []byte) {
+	b.batch.Delete(key)
+}
+
+func (b *levelBatch) DeleteRange(from, to []byte) {
+	it := b.l.db.NewIterator(&util.Range{Start: from, Limit: to}, nil)
+	defer it.Release()
+
+	for it.Next() {
+		b.batch.Delete(append([]byte{}, it.Key()...))
+	}
+}
+
+func (b *levelBatch) Destroy() {
+	b.batch.Reset()
+}
+
+func (l *LevelDB) WriteBatch(batch KVBatch) error {
+	return l.db.Write(batch.(*levelBatch).batch, nil)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLevelDB_MigrateSchema(t *testing.T) {
+	store, err := NewLevelDB(t.TempDir(), &RocksDBOptions{})
+	if err != nil {
+		t.Fatalf("NewLevelDB failed: %v", err)
+	}
+	defer store.Close()
+
+	if err = MigrateSchema(store, Migrations, SchemaVersion); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
+	}
+
+	version, exists, err := GetSchemaVersion(store)
+	if err != nil || !exists || version != SchemaVersion {
+		t.Fatalf("GetSchemaVersion: want %d, got %d, %v, %v", SchemaVersion, version, exists, err)
+	}
+
+	// the pool index is built on every backend
+	if err = store.Set(SchemaVersionKey, uint32ToBytes(3)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err = MigrateSchema(store, Migrations, SchemaVersion); err != nil {
+		t.Fatalf("MigrateSchema from version 3 failed: %v", err)
+	}
+
+	// moving keys into column families needs RocksDB
+	if err = store.Set(SchemaVersionKey, uint32ToBytes(2)); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err = MigrateSchema(store, Migrations, SchemaVersion); err == nil {
+		t.Fatalf("MigrateSchema of a version 2 leveldb: want error")
+	}
+}
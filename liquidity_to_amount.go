@@ -20,6 +20,11 @@ type RangeLiquidity struct {
 }
 
 func BuildRangeLiquidityArray(tickStates []*TickState) []*RangeLiquidity {
+	return BuildRangeLiquidityArrayFrom(big.NewInt(0), tickStates)
+}
+
+// BuildRangeLiquidityArrayFrom 用于tick窗口，baseLiquidity为窗口第一个tick之下的liquidityNet之和
+func BuildRangeLiquidityArrayFrom(baseLiquidity *big.Int, tickStates []*TickState) []*RangeLiquidity {
 	if len(tickStates) == 0 {
 		return nil
 	}
@@ -32,7 +37,7 @@ func BuildRangeLiquidityArray(tickStates []*TickState) []*RangeLiquidity {
 
 	// 计算每个tick区间的liquidity前缀和
 	prefixLiquidity := make([]*big.Int, len(tickStates))
-	currentLiquidity := big.NewInt(0)
+	currentLiquidity := new(big.Int).Set(baseLiquidity)
 	for i, t := range tickStates {
 		currentLiquidity = new(big.Int).Add(currentLiquidity, t.LiquidityNet)
 		prefixLiquidity[i] = new(big.Int).Set(currentLiquidity)
@@ -51,6 +56,18 @@ func BuildRangeLiquidityArray(tickStates []*TickState) []*RangeLiquidity {
 	return rangeLiquidity
 }
 
+// CalcActiveLiquidity 计算当前tick下的活跃流动性，即所有 tick <= currentTick 的 LiquidityNet 之和
+func CalcActiveLiquidity(tickStates []*TickState, currentTick int32) *big.Int {
+	liquidity := big.NewInt(0)
+	for _, t := range tickStates {
+		if t.Tick > currentTick {
+			break
+		}
+		liquidity.Add(liquidity, t.LiquidityNet)
+	}
+	return liquidity
+}
+
 func CalcRangeAmountArray(rangeLiquidityArray []*RangeLiquidity, token0Decimals, token1Decimals int) []*RangeAmount {
 	if len(rangeLiquidityArray) == 0 {
 		return nil
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcActiveLiquidity(t *testing.T) {
+	tickStates := []*TickState{
+		{Tick: -120, LiquidityNet: big.NewInt(100)},
+		{Tick: -60, LiquidityNet: big.NewInt(50)},
+		{Tick: 60, LiquidityNet: big.NewInt(-50)},
+		{Tick: 120, LiquidityNet: big.NewInt(-100)},
+	}
+
+	require.Equal(t, int64(0), CalcActiveLiquidity(tickStates, -121).Int64())
+	require.Equal(t, int64(100), CalcActiveLiquidity(tickStates, -120).Int64())
+	require.Equal(t, int64(150), CalcActiveLiquidity(tickStates, 0).Int64())
+	require.Equal(t, int64(100), CalcActiveLiquidity(tickStates, 60).Int64())
+	require.Equal(t, int64(0), CalcActiveLiquidity(tickStates, 887272).Int64())
+}
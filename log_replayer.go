@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"uniswapv3-tick-state/abi_instance"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"go.uber.org/zap"
+)
+
+const (
+	BootstrapModeLens = "lens"
+	BootstrapModeLogs = "logs"
+)
+
+var (
+	replayTopics = [][]common.Hash{{
+		abi_instance.MintTopic0,
+		abi_instance.BurnTopic0,
+		abi_instance.SwapTopic0,
+		abi_instance.InitializeTopic0,
+	}}
+)
+
+// LogReplayer bootstraps a pool without the lens contract by replaying
+// all of its Mint/Burn/Swap/Initialize logs since the pool was created.
+type LogReplayer struct {
+	ethClient   *ethclient.Client
+	retryPolicy *RetryPolicy
+	rateLimiter *RateLimiter
+	blockRange  uint64
+}
+
+func NewLogReplayer(url string, blockRange uint64) *LogReplayer {
+	ethClient, err := ethclient.Dial(url)
+	if err != nil {
+		panic("failed to connect to Ethereum client: " + err.Error())
+	}
+
+	if blockRange == 0 {
+		blockRange = 5000
+	}
+
+	return &LogReplayer{
+		ethClient:   ethClient,
+		retryPolicy: GetRetryPolicy(url),
+		rateLimiter: GetRateLimiter(url),
+		blockRange:  blockRange,
+	}
+}
+
+func (l *LogReplayer) GetPoolState(addr common.Address, fromHeight uint64) (*PoolState, error) {
+	ctx := context.Background()
+
+	height, err := DoWithRetry(ctx, l.retryPolicy, timeoutDuration, func(ctx context.Context) (uint64, error) {
+		return RateLimited(ctx, l.rateLimiter, CallTypeBlockNumber, PriorityLow, l.ethClient.BlockNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tickSpacing, err := l.getTickSpacing(ctx, addr, height)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := newPoolStateBuilder()
+	for from := fromHeight; from <= height; from += l.blockRange {
+		to := min(from+l.blockRange-1, height)
+		logs, err := l.filterLogs(ctx, addr, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = replayLogs(builder, logs); err != nil {
+			return nil, err
+		}
+	}
+
+	Log.Debug("replay logs finished", zap.String("addr", addr.String()), zap.Uint64("from", fromHeight), zap.Uint64("to", height), zap.Int("ticks", len(builder.tickStates)))
+	return builder.PoolState(height, tickSpacing), nil
+}
+
+// replayLogs applies the logs to the builder. Only logs of other topics are
+// skipped, a replayed log that fails to parse would leave a wrong tick map.
+func replayLogs(builder *poolStateBuilder, logs []types.Log) error {
+	for i := range logs {
+		if logs[i].Removed || len(logs[i].Topics) == 0 {
+			continue
+		}
+
+		event, err := ParseLog(&logs[i])
+		if errors.Is(err, ErrUnknownLogTopic) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("parse log %d of block %d err: %w", logs[i].Index, logs[i].BlockNumber, err)
+		}
+
+		if err = ApplyEvent(builder, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LogReplayer) filterLogs(ctx context.Context, addr common.Address, from, to uint64) ([]types.Log, error) {
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{addr},
+		Topics:    replayTopics,
+	}
+
+	return DoWithRetry(ctx, l.retryPolicy, timeoutDuration, func(ctx context.Context) ([]types.Log, error) {
+		return RateLimited(ctx, l.rateLimiter, CallTypeGetLogs, PriorityLow, func(ctx context.Context) ([]types.Log, error) {
+			return l.ethClient.FilterLogs(ctx, query)
+		})
+	})
+}
+
+func (l *LogReplayer) getTickSpacing(ctx context.Context, addr common.Address, height uint64) (int32, error) {
+	data, err := abi_instance.PoolAbi.Pack("tickSpacing")
+	if err != nil {
+		return 0, err
+	}
+
+	bytes, err := DoWithRetry(ctx, l.retryPolicy, timeoutDuration, func(ctx context.Context) ([]byte, error) {
+		return RateLimited(ctx, l.rateLimiter, CallTypeEthCall, PriorityLow, func(ctx context.Context) ([]byte, error) {
+			return l.ethClient.CallContract(ctx, ethereum.CallMsg{To: &addr, Data: data}, new(big.Int).SetUint64(height))
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(bytes) == 0 {
+		return 0, ErrEmptyOutput
+	}
+
+	outputs, err := abi_instance.PoolAbi.Unpack("tickSpacing", bytes)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(outputs[0].(*big.Int).Int64()), nil
+}
+
+// poolStateBuilder is an in-memory TickStore for a single pool
+type poolStateBuilder struct {
+	tick       int32
+	tickStates map[int32]*TickState
+}
+
+func newPoolStateBuilder() *poolStateBuilder {
+	return &poolStateBuilder{
+		tickStates: make(map[int32]*TickState),
+	}
+}
+
+func (b *poolStateBuilder) GetTickState(_ common.Address, tick int32) (*TickState, error) {
+	return b.tickStates[tick], nil
+}
+
+func (b *poolStateBuilder) SetTickState(_ common.Address, tickState *TickState) error {
+	b.tickStates[tickState.Tick] = tickState
+	return nil
+}
+
+func (b *poolStateBuilder) SetCurrentTick(_ common.Address, tick int32) error {
+	b.tick = tick
+	return nil
+}
+
+func (b *poolStateBuilder) PoolState(height uint64, tickSpacing int32) *PoolState {
+	tickStates := make([]*TickState, 0, len(b.tickStates))
+	for _, ts := range b.tickStates {
+		if ts.LiquidityNet.Sign() == 0 {
+			continue
+		}
+		tickStates = append(tickStates, ts)
+	}
+	sort.Slice(tickStates, func(i, j int) bool {
+		return tickStates[i].Tick < tickStates[j].Tick
+	})
+
+	return &PoolState{
+		Global: &PoolGlobalState{
+			Height:      new(big.Int).SetUint64(height),
+			TickSpacing: big.NewInt(int64(tickSpacing)),
+			Tick:        big.NewInt(int64(b.tick)),
+		},
+		TickStates: tickStates,
+	}
+}
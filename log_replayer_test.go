@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolStateBuilder_Replay(t *testing.T) {
+	addr := common.HexToAddress("0xb00000000000000000000000000000000000000b")
+	events := []*Event{
+		{Address: addr, Type: EventTypeInitialize, Tick: big.NewInt(5)},
+		{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(-60), TickUpper: big.NewInt(60), Amount: big.NewInt(1000)},
+		{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(0), TickUpper: big.NewInt(120), Amount: big.NewInt(500)},
+		{Address: addr, Type: EventTypeBurn, TickLower: big.NewInt(0), TickUpper: big.NewInt(120), Amount: big.NewInt(500)},
+		{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(-10), Liquidity: big.NewInt(1000)},
+	}
+
+	builder := newPoolStateBuilder()
+	for _, event := range events {
+		require.NoError(t, ApplyEvent(builder, event))
+	}
+
+	poolState := builder.PoolState(100, 60)
+	require.Equal(t, int64(100), poolState.Global.Height.Int64())
+	require.Equal(t, int64(60), poolState.Global.TickSpacing.Int64())
+	require.Equal(t, int64(-10), poolState.Global.Tick.Int64())
+	require.Len(t, poolState.TickStates, 2, "fully burned ticks should be dropped")
+	require.True(t, poolState.TickStates[0].Equal(&TickState{Tick: -60, LiquidityNet: big.NewInt(1000)}))
+	require.True(t, poolState.TickStates[1].Equal(&TickState{Tick: 60, LiquidityNet: big.NewInt(-1000)}))
+}
+
+func TestReplayLogs(t *testing.T) {
+	addr := common.HexToAddress("0x1b0000000000000000000000000000000000001b")
+	sqrtPriceX96 := big.NewInt(1 << 40)
+
+	unknown := types.Log{Address: addr, Topics: []common.Hash{common.HexToHash("0x01")}}
+	removed := *newTestMintLog(t, addr, 0, 60, 700)
+	removed.Removed = true
+	logs := []types.Log{
+		*newTestInitializeLog(t, addr, sqrtPriceX96, 30),
+		unknown,
+		removed,
+		*newTestMintLog(t, addr, 0, 120, 1000),
+	}
+
+	builder := newPoolStateBuilder()
+	require.NoError(t, replayLogs(builder, logs))
+	poolState := builder.PoolState(100, 60)
+	require.Equal(t, int64(30), poolState.Global.Tick.Int64())
+	require.Equal(t, map[int32]int64{0: 1000, 120: -1000}, tickStatesToMap(poolState.TickStates))
+
+	// a replayed topic that does not parse fails the bootstrap
+	malformed := *newTestMintLog(t, addr, 0, 120, 1000)
+	malformed.Topics = malformed.Topics[:3]
+	malformed.BlockNumber = 42
+	err := replayLogs(newPoolStateBuilder(), append(logs, malformed))
+	require.ErrorIs(t, err, ErrWrongTopicLen)
+	require.Contains(t, err.Error(), "block 42")
+}
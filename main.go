@@ -9,6 +9,7 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
@@ -23,6 +24,27 @@ func main() {
 	var dbPath string
 	flag.StringVar(&dbPath, "db", "", "database path (overrides config file)")
 
+	var serveOnlyMode bool
+	flag.BoolVar(&serveOnlyMode, "serve-only", false, "only serve the api from a secondary instance of the database")
+
+	var importCheckpoint string
+	flag.StringVar(&importCheckpoint, "import-checkpoint", "", "install a checkpoint archive at the database path and exit")
+
+	var restoreBackup string
+	flag.StringVar(&restoreBackup, "restore-backup", "", "restore the database path from a backup dir and exit")
+
+	var backupID uint
+	flag.UintVar(&backupID, "backup-id", 0, "backup to restore, 0 for the latest")
+
+	var force bool
+	flag.BoolVar(&force, "force", false, "let -restore-backup overwrite a non-empty database path")
+
+	var verifyMode bool
+	flag.BoolVar(&verifyMode, "verify", false, "check the invariants of the database, print a report and exit non-zero on violations")
+
+	var addr string
+	flag.StringVar(&addr, "addr", "", "api listen address (overrides config file)")
+
 	flag.Parse()
 
 	if showVersion {
@@ -41,9 +63,41 @@ func main() {
 		G.RocksDB.DBPath = dbPath
 	}
 
+	if addr != "" {
+		G.API.Addr = addr
+	}
+
 	ctx := context.Background()
 
-	rocksDB, err := NewRocksDB(G.RocksDB.DBPath, &RocksDBOptions{
+	if restoreBackup != "" {
+		if err = RestoreBackup(restoreBackup, G.RocksDB.DBPath, uint32(backupID), force); err != nil {
+			Log.Fatal("failed to restore backup", zap.Error(err))
+		}
+		return
+	}
+
+	if verifyMode {
+		os.Exit(verify())
+	}
+
+	if serveOnlyMode {
+		serveOnly(ctx)
+		return
+	}
+
+	chainID, err := getChainID(ctx, G.EthRPC.HTTP)
+	if err != nil {
+		Log.Fatal("failed to get chain id", zap.Error(err))
+	}
+
+	if importCheckpoint != "" {
+		if _, err = ImportCheckpoint(importCheckpoint, G.RocksDB.DBPath, chainID); err != nil {
+			Log.Fatal("failed to import checkpoint", zap.Error(err))
+		}
+		return
+	}
+
+	store, err := OpenKVStore(G.RocksDB.Backend, G.RocksDB.DBPath, &RocksDBOptions{
 		EnableLog:            G.RocksDB.EnableLog,
 		BlockCacheSize:       G.RocksDB.BlockCacheSize,
 		WriteBufferSize:      G.RocksDB.WriteBufferSize,
@@ -52,23 +106,52 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	db := NewDB(rocksDB)
+
+	if err = MigrateSchema(store, Migrations, SchemaVersion); err != nil {
+		Log.Fatal("failed to migrate db schema", zap.Error(err))
+	}
+
+	// checkpoints and backups need rocksdb
+	rocksDB, _ := store.(*RocksDB)
+
+	if G.Backup.Dir != "" {
+		if rocksDB == nil {
+			Log.Fatal("backups need the rocksdb backend", zap.String("backend", G.RocksDB.Backend))
+		}
+		backups, err := NewBackupScheduler(rocksDB, G.Backup)
+		if err != nil {
+			Log.Fatal("failed to open backup engine", zap.Error(err))
+		}
+		// closed with the db when the reactor shuts down
+		backups.Start(ctx)
+	}
+
+	var checkpointer *Checkpointer
+	if rocksDB != nil {
+		checkpointer = NewCheckpointer(rocksDB, chainID, G.RocksDB.CheckpointDir)
+	}
+
+	db := NewDB(store)
 	db = NewSafeDB(db)
 
-	redisCli := redis.NewClient(&redis.Options{
-		Addr:     G.Redis.Addr,
-		Username: G.Redis.Username,
-		Password: G.Redis.Password,
-	})
-	cache := NewTwoTierCache(redisCli)
+	cache := newCache()
 
-	psg := NewPoolStateGetter(cache, db, G.EthRPC.HTTP)
-	as := NewAPIServer(psg)
+	var logReplayer *LogReplayer
+	if G.Bootstrap.Mode == BootstrapModeLogs {
+		logReplayer = NewLogReplayer(G.EthRPC.Archive, G.Bootstrap.LogsBlockRange)
+	}
+	contractCaller := NewContractCaller(G.EthRPC.HTTP, G.Bootstrap.TicksPageSize, G.Bootstrap.MulticallBatchSize, G.Bootstrap.LensOverride)
+	psg := NewPoolStateGetter(cache, db, contractCaller, logReplayer)
+	as := NewAPIServer(G.API.Addr, G.API.AdminAddr, psg, db, checkpointer)
 	as.Start()
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	reactor := NewEventReactor(wg, db, psg)
+	poolPruner, err := NewPoolPruner(db, cache, G.EventReactor)
+	if err != nil {
+		Log.Fatal("invalid pool pruning policy", zap.Error(err))
+	}
+	reactor := NewEventReactor(wg, db, psg, poolPruner, G.EventReactor)
 	parser := NewBlockParser()
 	parser.MountOutput(reactor)
 
@@ -99,3 +182,26 @@ func main() {
 	wg.Wait()
 	Log.Info("done")
 }
+
+func newCache() Cache {
+	redisCli := redis.NewClient(&redis.Options{
+		Addr:     G.Redis.Addr,
+		Username: G.Redis.Username,
+		Password: G.Redis.Password,
+	})
+	return NewTwoTierCache(redisCli)
+}
+
+func getChainID(ctx context.Context, url string) (uint64, error) {
+	ethClient, err := ethclient.Dial(url)
+	if err != nil {
+		return 0, err
+	}
+	defer ethClient.Close()
+
+	chainID, err := ethClient.ChainID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return chainID.Uint64(), nil
+}
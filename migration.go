@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"go.uber.org/zap"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// The schema version tells how keys and values are laid out. A db without the
+// version key is either empty or written before versioning (version 0).
+//
+//	0:version -> uint32 schema version
+//	0:migration -> uint32 version being migrated to | last rewritten key
+
+var (
+	SchemaVersionKey     = dbkey.SchemaVersionKey
+	MigrationProgressKey = dbkey.MigrationProgressKey
+)
+
+const (
+	SchemaVersion = uint32(5)
+)
+
+var (
+	migrationBatchSize = 10000
+)
+
+var (
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+)
+
+// Migration moves the db from Version-1 to Version. Rewrite is called in key
+// order for every entry of [From, To] and records its changes into batch. Each
+// batch is written together with the last rewritten key, so after a crash the
+// migration resumes behind it and no entry is rewritten twice. Rewrite must not
+// put keys into the part of [From, To] that is not visited yet, it may read
+// other keys from db. When CF is set the range is read from that column family
+// and batch writes into it, which needs RocksDB; otherwise keys are routed by
+// their prefix and the migration runs on every backend.
+type Migration struct {
+	Version uint32
+	Name    string
+	CF      string
+	From    []byte
+	To      []byte
+	Rewrite func(db KVStore, key, value []byte, batch KVBatch) error
+}
+
+var (
+	Migrations = []*Migration{
+		{
+			// the layout before versioning is the layout of version 1
+			Version: 1,
+			Name:    "stamp schema version",
+		},
+		{
+			Version: 2,
+			Name:    "fixed-width tick values",
+			CF:      CFDefault,
+			From:    KeyPrefixTickState,
+			To:      append(append([]byte{}, KeyPrefixCurrentTickHistory...), 0xff),
+			Rewrite: rewriteFixedWidthValues,
+		},
+		{
+			Version: 3,
+			Name:    "move pool keys to column families",
+			CF:      CFDefault,
+			From:    KeyPrefixTickState,
+			To:      append(append([]byte{}, KeyPrefixEvent...), 0xff),
+			Rewrite: moveToColumnFamily,
+		},
+		{
+			Version: 4,
+			Name:    "index pools",
+			From:    KeyPrefixPoolHeight,
+			To:      append(append([]byte{}, KeyPrefixPoolHeight...), 0xff),
+			Rewrite: indexPool,
+		},
+		{
+			Version: 5,
+			Name:    "sort keys of the pool index",
+			From:    KeyPrefixPoolInfo,
+			To:      append(append([]byte{}, KeyPrefixPoolInfo...), 0xff),
+			Rewrite: sortPool,
+		},
+	}
+)
+
+// moveToColumnFamily moves a key of the default column family to the column family of its prefix
+func moveToColumnFamily(db KVStore, key, value []byte, batch KVBatch) error {
+	cf := ColumnFamilyOf(key)
+	if cf == CFDefault {
+		return nil
+	}
+
+	batch.(*Batch).PutCF(cf, key, value)
+	batch.Delete(key)
+	return nil
+}
+
+// rewriteFixedWidthValues turns gob liquidityNet into int128 and plain int32 ticks into the ordered form
+func rewriteFixedWidthValues(db KVStore, key, value []byte, batch KVBatch) error {
+	switch string(key[:2]) {
+	case string(KeyPrefixTickState), string(KeyPrefixTickHistory):
+		liquidityNet := new(big.Int)
+		if err := liquidityNet.GobDecode(value); err != nil {
+			return fmt.Errorf("decode gob value of key %x err: %w", key, err)
+		}
+
+		newValue, err := int128ToBytes(liquidityNet)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, newValue)
+
+	case string(KeyPrefixCurrentTick), string(KeyPrefixTickSpacing), string(KeyPrefixCurrentTickHistory):
+		if len(value) != 4 {
+			return fmt.Errorf("%w: key %x", ErrWrongValueLen, key)
+		}
+		batch.Put(key, int32ToOrderedBytes(int32(binary.BigEndian.Uint32(value))))
+	}
+
+	return nil
+}
+
+func GetSchemaVersion(db KVStore) (version uint32, exists bool, err error) {
+	bytes, err := db.Get(SchemaVersionKey)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if bytes == nil {
+		return 0, false, nil
+	}
+
+	return binary.BigEndian.Uint32(bytes), true, nil
+}
+
+func isEmpty(db KVStore) (bool, error) {
+	rocksDB, ok := db.(*RocksDB)
+	if !ok {
+		empty := true
+		err := db.Scan([]byte{}, []byte{0xff}, func(key, value []byte) error {
+			empty = false
+			return ErrStopScan
+		})
+		return empty, err
+	}
+
+	for _, cf := range ColumnFamilies {
+		entries, err := rocksDB.GetRangeLimitCF(cf, []byte{}, []byte{0xff}, 1)
+		if err != nil {
+			return false, err
+		}
+
+		if len(entries) != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MigrateSchema brings the db to version target through migrations, a new db is stamped with target directly.
+// Migrations with a column family only run on RocksDB, the other backends start at version 3.
+func MigrateSchema(db KVStore, migrations []*Migration, target uint32) error {
+	version, exists, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		empty, err := isEmpty(db)
+		if err != nil {
+			return err
+		}
+
+		if empty {
+			Log.Info("new db", zap.Uint32("schemaVersion", target))
+			return db.Set(SchemaVersionKey, uint32ToBytes(target))
+		}
+	}
+
+	if version > target {
+		return fmt.Errorf("%w: db=%d, supported=%d", ErrUnknownSchemaVersion, version, target)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version || m.Version > target {
+			continue
+		}
+
+		if m.Version != version+1 {
+			return fmt.Errorf("no migration from schema version %d to %d", version, version+1)
+		}
+
+		if _, ok := db.(*RocksDB); !ok && m.CF != "" {
+			return fmt.Errorf("no migration from schema version %d on %T", version, db)
+		}
+
+		if err = runMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) err: %w", m.Version, m.Name, err)
+		}
+		version = m.Version
+	}
+
+	if version != target {
+		return fmt.Errorf("no migration from schema version %d to %d", version, target)
+	}
+
+	return nil
+}
+
+// migrationRange reads up to limit entries of [from, to] for a migration
+func migrationRange(db KVStore, cf string, from, to []byte, limit int) ([]KVEntry, error) {
+	if rocksDB, ok := db.(*RocksDB); ok {
+		if cf != "" {
+			return rocksDB.GetRangeLimitCF(cf, from, to, limit)
+		}
+		return rocksDB.GetRangeLimit(from, to, limit)
+	}
+
+	var entries []KVEntry
+	err := db.Scan(from, to, func(key, value []byte) error {
+		entries = append(entries, &bytesEntry{key: append([]byte{}, key...), val: append([]byte{}, value...)})
+		if len(entries) == limit {
+			return ErrStopScan
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// newMigrationBatch writes into cf, or routes keys by prefix when cf is empty
+func newMigrationBatch(db KVStore, cf string) KVBatch {
+	if rocksDB, ok := db.(*RocksDB); ok {
+		return rocksDB.NewBatchCF(cf)
+	}
+	return db.NewBatch()
+}
+
+// putMeta puts a 0: key of the default column family whatever the column family of batch
+func putMeta(batch KVBatch, key, value []byte) {
+	if b, ok := batch.(*Batch); ok {
+		b.PutCF(CFDefault, key, value)
+		return
+	}
+	batch.Put(key, value)
+}
+
+func deleteMeta(batch KVBatch, key []byte) {
+	if b, ok := batch.(*Batch); ok {
+		b.DeleteCF(CFDefault, key)
+		return
+	}
+	batch.Delete(key)
+}
+
+func runMigration(db KVStore, m *Migration) error {
+	start := m.From
+	progress, err := db.Get(MigrationProgressKey)
+	if err != nil {
+		return err
+	}
+
+	if len(progress) > 4 && binary.BigEndian.Uint32(progress[:4]) == m.Version {
+		start = append(progress[4:], 0)
+		Log.Info("migration resumed", zap.Uint32("version", m.Version), zap.String("name", m.Name))
+	} else {
+		Log.Info("migration started", zap.Uint32("version", m.Version), zap.String("name", m.Name))
+	}
+
+	rewritten := 0
+	for {
+		var entries []KVEntry
+		if m.Rewrite != nil {
+			if entries, err = migrationRange(db, m.CF, start, m.To, migrationBatchSize); err != nil {
+				return err
+			}
+		}
+
+		batch := newMigrationBatch(db, m.CF)
+		for _, entry := range entries {
+			if err = m.Rewrite(db, entry.K(), entry.V(), batch); err != nil {
+				batch.Destroy()
+				return err
+			}
+		}
+
+		done := len(entries) < migrationBatchSize
+		if done {
+			putMeta(batch, SchemaVersionKey, uint32ToBytes(m.Version))
+			deleteMeta(batch, MigrationProgressKey)
+		} else {
+			lastKey := entries[len(entries)-1].K()
+			putMeta(batch, MigrationProgressKey, append(uint32ToBytes(m.Version), lastKey...))
+			start = append(append([]byte{}, lastKey...), 0)
+		}
+
+		err = db.WriteBatch(batch)
+		batch.Destroy()
+		if err != nil {
+			return err
+		}
+
+		rewritten += len(entries)
+		if done {
+			Log.Info("migration finished", zap.Uint32("version", m.Version), zap.Int("entries", rewritten))
+			return nil
+		}
+		Log.Info("migration progress", zap.Uint32("version", m.Version), zap.Int("entries", rewritten))
+	}
+}
@@ -0,0 +1,153 @@
+//go:build !purego
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func Test_MigrateSchema_NewDB(t *testing.T) {
+	db := newTestRocksDB(t)
+	defer db.Close()
+
+	if err := MigrateSchema(db, Migrations, SchemaVersion); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
+	}
+
+	version, exists, err := GetSchemaVersion(db)
+	if err != nil || !exists || version != SchemaVersion {
+		t.Fatalf("GetSchemaVersion: want %d, got %d %v %v", SchemaVersion, version, exists, err)
+	}
+
+	if err = MigrateSchema(db, Migrations, SchemaVersion-1); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Fatalf("MigrateSchema to an older version: want ErrUnknownSchemaVersion, got %v", err)
+	}
+}
+
+func Test_MigrateSchema_Resume(t *testing.T) {
+	db := newTestRocksDB(t)
+	defer db.Close()
+
+	oldBatchSize := migrationBatchSize
+	migrationBatchSize = 3
+	defer func() { migrationBatchSize = oldBatchSize }()
+
+	// a legacy db: data but no version key
+	for i := 0; i < 10; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("2:%02d", i)), []byte{1}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	crash := true
+	calls := 0
+	migrations := append([]*Migration{}, Migrations[:1]...)
+	migrations = append(migrations, &Migration{
+		Version: 2,
+		Name:    "append a byte",
+		From:    []byte("2:"),
+		To:      []byte("2:~"),
+		Rewrite: func(db KVStore, key, value []byte, batch KVBatch) error {
+			calls++
+			if crash && calls == 5 {
+				return errors.New("crash")
+			}
+			batch.Put(key, append(value, 2))
+			return nil
+		},
+	})
+
+	if err := MigrateSchema(db, migrations, 2); err == nil {
+		t.Fatalf("MigrateSchema: want crash")
+	}
+
+	version, _, _ := GetSchemaVersion(db)
+	if version != 1 {
+		t.Fatalf("GetSchemaVersion after crash: want 1, got %d", version)
+	}
+
+	crash = false
+	if err := MigrateSchema(db, migrations, 2); err != nil {
+		t.Fatalf("MigrateSchema resume failed: %v", err)
+	}
+
+	version, _, _ = GetSchemaVersion(db)
+	if version != 2 {
+		t.Fatalf("GetSchemaVersion: want 2, got %d", version)
+	}
+
+	for i := 0; i < 10; i++ {
+		value, _ := db.Get([]byte(fmt.Sprintf("2:%02d", i)))
+		if string(value) != string([]byte{1, 2}) {
+			t.Fatalf("key %d: want rewritten once, got %v", i, value)
+		}
+	}
+
+	progress, _ := db.Get(MigrationProgressKey)
+	if progress != nil {
+		t.Fatalf("migration progress not cleared: %v", progress)
+	}
+}
+
+func Test_MigrateSchema_Version1(t *testing.T) {
+	db := newTestRocksDB(t)
+	defer db.Close()
+	addr := common.HexToAddress("0x8000000000000000000000000000000000000008")
+
+	// version 1 values: gob liquidityNet and plain int32 ticks
+	gob, _ := big.NewInt(-123456).GobEncode()
+	tickKey := GetTickStateKey(addr, -60)
+	historyKey := makeTickHistoryKey(addr, -60, 100)
+	currentTickKey := makeCurrentTickKey(addr)
+	spacingKey := makeTickSpacingKey(addr)
+	poolHeightKey := makePoolHeightKey(addr)
+	for key, value := range map[string][]byte{
+		string(tickKey.GetKey()):  gob,
+		string(historyKey[:]):     gob,
+		string(currentTickKey[:]): {0xff, 0xff, 0xff, 0xc4}, // -60
+		string(spacingKey[:]):     {0, 0, 0, 60},
+		string(SchemaVersionKey):  uint32ToBytes(1),
+		string(poolHeightKey[:]):  uint64ToBytes(100),
+	} {
+		// before version 3 everything is in the default column family
+		if err := db.db.PutCF(db.wo, db.cfs[CFDefault], []byte(key), value); err != nil {
+			t.Fatalf("PutCF failed: %v", err)
+		}
+	}
+
+	if err := MigrateSchema(db, Migrations, SchemaVersion); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
+	}
+
+	repo := NewDB(db)
+	ts, err := repo.GetTickState(addr, -60)
+	if err != nil || ts.LiquidityNet.Int64() != -123456 {
+		t.Fatalf("GetTickState: want -123456, got %v, %v", ts, err)
+	}
+
+	tick, err := repo.GetCurrentTick(addr)
+	if err != nil || tick != -60 {
+		t.Fatalf("GetCurrentTick: want -60, got %d, %v", tick, err)
+	}
+
+	tickSpacing, err := repo.GetTickSpacing(addr)
+	if err != nil || tickSpacing != 60 {
+		t.Fatalf("GetTickSpacing: want 60, got %d, %v", tickSpacing, err)
+	}
+
+	height, err := repo.GetHeight(addr)
+	if err != nil || height != 100 {
+		t.Fatalf("GetHeight: want 100, got %d, %v", height, err)
+	}
+
+	// the pool sort keys after c: stay in the default column family
+	entries, err := db.GetRangeLimitCF(CFDefault, KeyPrefixTickState, append(append([]byte{}, KeyPrefixPoolInfo...), 0xff), 10)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("pool keys left in the default column family: %d, %v", len(entries), err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"uniswapv3-tick-state/abi_instance"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+var (
+	aggregate3Method = abi_instance.Multicall3ABI.Methods["aggregate3"]
+)
+
+type multicall3Result = struct {
+	Success    bool   `json:"success"`
+	ReturnData []byte `json:"returnData"`
+}
+
+// GetPoolStates bootstraps many pools with getAllTicks calls grouped into Multicall3
+// aggregates of at most multicallBatchSize pools, all at the same block number.
+// Pools whose call fails inside an aggregate fall back to GetPoolState one by one
+// at that block, pools failing there too are logged and left out of the result.
+func (c *ContractCaller) GetPoolStates(addrs []common.Address) (map[common.Address]*PoolState, error) {
+	result := make(map[common.Address]*PoolState, len(addrs))
+	if len(addrs) == 0 {
+		return result, nil
+	}
+
+	height, err := DoWithRetry(context.Background(), c.retryPolicy, timeoutDuration, func(ctx context.Context) (uint64, error) {
+		return RateLimited(ctx, c.rateLimiter, CallTypeBlockNumber, PriorityLow, c.ethClient.BlockNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+	blockNumber := new(big.Int).SetUint64(height)
+
+	var failed []common.Address
+	for from := 0; from < len(addrs); from += c.multicallBatchSize {
+		to := min(from+c.multicallBatchSize, len(addrs))
+		failed = append(failed, c.aggregateAllTicks(addrs[from:to], blockNumber, result)...)
+	}
+
+	for _, addr := range failed {
+		poolState, err := c.getPoolState(addr, blockNumber)
+		if err != nil {
+			Log.Warn("bootstrap pool failed", zap.String("addr", addr.String()), zap.Uint64("height", height), zap.Error(err))
+			continue
+		}
+		result[addr] = poolState
+	}
+
+	return result, nil
+}
+
+// aggregateAllTicks fills result with the pools loaded by one aggregate3 call and
+// returns the pools that failed. A failing aggregate is split in halves and retried.
+func (c *ContractCaller) aggregateAllTicks(addrs []common.Address, blockNumber *big.Int, result map[common.Address]*PoolState) []common.Address {
+	results, err := c.aggregate3(addrs, blockNumber)
+	if err != nil {
+		Log.Warn("aggregate3 failed", zap.Int("pools", len(addrs)), zap.Error(err))
+		if len(addrs) == 1 {
+			return addrs
+		}
+		mid := len(addrs) / 2
+		failed := c.aggregateAllTicks(addrs[:mid], blockNumber, result)
+		return append(failed, c.aggregateAllTicks(addrs[mid:], blockNumber, result)...)
+	}
+
+	var failed []common.Address
+	for i, r := range results {
+		if !r.Success || len(r.ReturnData) == 0 {
+			failed = append(failed, addrs[i])
+			continue
+		}
+
+		poolState, err := unpackAllTicks(r.ReturnData)
+		if err != nil {
+			Log.Warn("unpack getAllTicks failed", zap.String("addr", addrs[i].String()), zap.Error(err))
+			failed = append(failed, addrs[i])
+			continue
+		}
+		result[addrs[i]] = poolState
+	}
+
+	return failed
+}
+
+func (c *ContractCaller) aggregate3(addrs []common.Address, blockNumber *big.Int) ([]multicall3Result, error) {
+	calls := make([]abi_instance.Multicall3Call, 0, len(addrs))
+	for _, addr := range addrs {
+		data, err := abi_instance.LensABI.Pack("getAllTicks", addr)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, abi_instance.Multicall3Call{
+			Target:       c.lensAddress(),
+			AllowFailure: true,
+			CallData:     data,
+		})
+	}
+
+	data, err := abi_instance.Multicall3ABI.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &CallContractReq{
+		BlockNumber: blockNumber,
+		Address:     abi_instance.Multicall3Address,
+		Data:        data,
+		Overrides:   c.lensOverrides(),
+	}
+
+	bytes, err := c.CallContract(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes) == 0 {
+		return nil, ErrEmptyOutput
+	}
+
+	outputs, err := aggregate3Method.Outputs.Unpack(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	results := outputs[0].([]multicall3Result)
+	if len(results) != len(addrs) {
+		return nil, fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(addrs))
+	}
+
+	return results, nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// Every pool the store holds has an index entry, kept with its state, and a
+// key per sort order that ListPools pages through. The sort value is inverted
+// so the keys run newest / most first, they live in the default column family
+// as they do not start with the pool address:
+//
+//	c: | addr -> PoolInfo json
+//	d: | ^last updated height | addr -> last updated height
+//	e: | ^tick count | addr -> tick count
+
+var (
+	KeyPrefixPoolInfo       = dbkey.PrefixPoolInfo
+	KeyPrefixPoolByActivity = dbkey.PrefixPoolByActivity
+	KeyPrefixPoolByTicks    = dbkey.PrefixPoolByTicks
+)
+
+// sort orders of ListPools
+const (
+	PoolSortAddress  = "address"
+	PoolSortActivity = "activity" // last updated height, newest first
+	PoolSortTicks    = "ticks"    // tick count, most first
+)
+
+var (
+	// poolSortPrefixes are the key prefixes of the sort orders with an index of their own
+	poolSortPrefixes = map[string][]byte{
+		PoolSortActivity: KeyPrefixPoolByActivity,
+		PoolSortTicks:    KeyPrefixPoolByTicks,
+	}
+)
+
+var (
+	ErrWrongPoolSort = errors.New("wrong pool sort")
+)
+
+// PoolInfo is the index entry of a pool. TickCount counts the ticks with a non-zero
+// liquidityNet, EventCounts the applied events by type, also when they are not stored.
+// PrunedHeight is the tombstone of a pruned pool, cleared when it is bootstrapped again.
+type PoolInfo struct {
+	Address           common.Address    `json:"address"`
+	FirstSeenHeight   uint64            `json:"firstSeenHeight"`
+	LastUpdatedHeight uint64            `json:"lastUpdatedHeight"`
+	TickCount         int64             `json:"tickCount"`
+	EventCounts       map[string]uint64 `json:"eventCounts"`
+	PrunedHeight      uint64            `json:"prunedHeight,omitempty"`
+}
+
+// PoolQuery selects a page of pools in Sort order
+type PoolQuery struct {
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+// PoolPage is one page of pools, NextCursor is empty on the last page
+type PoolPage struct {
+	Pools      []*PoolInfo `json:"pools"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+func makePoolInfoKey(addr common.Address) [22]byte {
+	var key [22]byte
+	copy(key[:2], KeyPrefixPoolInfo)
+	copy(key[2:22], addr[:])
+	return key
+}
+
+func makePoolSortKey(sortBy string, value uint64, addr common.Address) [30]byte {
+	var key [30]byte
+	copy(key[:2], poolSortPrefixes[sortBy])
+	binary.BigEndian.PutUint64(key[2:10], ^value)
+	copy(key[10:30], addr[:])
+	return key
+}
+
+// sortKeys are the sort index keys of the entry, nil for a pool not indexed yet
+func (info *PoolInfo) sortKeys() [][30]byte {
+	if info == nil {
+		return nil
+	}
+
+	keys := make([][30]byte, 0, len(poolSortPrefixes))
+	for sortBy := range poolSortPrefixes {
+		keys = append(keys, makePoolSortKey(sortBy, poolSortKey(sortBy, info), info.Address))
+	}
+	return keys
+}
+
+func newPoolInfo(addr common.Address, height uint64) *PoolInfo {
+	return &PoolInfo{
+		Address:         addr,
+		FirstSeenHeight: height,
+		EventCounts:     make(map[string]uint64),
+	}
+}
+
+// getPoolInfo returns nil if the pool is not indexed
+func getPoolInfo(db KVStore, addr common.Address) (*PoolInfo, error) {
+	key := makePoolInfoKey(addr)
+	value, err := db.Get(key[:])
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	info := &PoolInfo{}
+	if err = json.Unmarshal(value, info); err != nil {
+		return nil, fmt.Errorf("decode pool info of %s err: %w", addr, err)
+	}
+	if info.EventCounts == nil {
+		info.EventCounts = make(map[string]uint64)
+	}
+	return info, nil
+}
+
+// putPoolInfo writes the index entry and moves its sort keys, prevKeys are the
+// sort keys of the stored entry it replaces
+func putPoolInfo(batch KVBatch, prevKeys [][30]byte, info *PoolInfo) error {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	key := makePoolInfoKey(info.Address)
+	batch.Put(key[:], value)
+
+	for _, prevKey := range prevKeys {
+		batch.Delete(prevKey[:])
+	}
+	putPoolSortKeys(batch, info)
+	return nil
+}
+
+func putPoolSortKeys(batch KVBatch, info *PoolInfo) {
+	for sortBy := range poolSortPrefixes {
+		value := poolSortKey(sortBy, info)
+		key := makePoolSortKey(sortBy, value, info.Address)
+		batch.Put(key[:], uint64ToBytes(value))
+	}
+}
+
+// indexBlock records the changes of a block in the index entries of its pools,
+// reading the tick states the block overwrites before they are written
+func (r *rocksDBWrap) indexBlock(w *BlockWrite, batch KVBatch) error {
+	for _, addr := range w.Addresses() {
+		info, err := getPoolInfo(r.db, addr)
+		if err != nil {
+			return err
+		}
+		prevKeys := info.sortKeys()
+		if info == nil {
+			info = newPoolInfo(addr, w.Height)
+		}
+		info.LastUpdatedHeight = w.Height
+		info.PrunedHeight = 0
+
+		for _, ts := range w.TickStates[addr] {
+			prev, err := r.GetTickState(addr, ts.Tick)
+			if err != nil {
+				return err
+			}
+			wasSet := prev != nil && prev.LiquidityNet.Sign() != 0
+			isSet := ts.LiquidityNet.Sign() != 0
+			if isSet && !wasSet {
+				info.TickCount++
+			} else if wasSet && !isSet {
+				info.TickCount--
+			}
+		}
+
+		for eventType, n := range w.EventCounts[addr] {
+			info.EventCounts[eventType] += n
+		}
+
+		if err = putPoolInfo(batch, prevKeys, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexPoolState resets the tick count of the pool to the bootstrapped state
+func (r *rocksDBWrap) indexPoolState(addr common.Address, poolState *PoolState, batch KVBatch) error {
+	height := poolState.Global.Height.Uint64()
+	info, err := getPoolInfo(r.db, addr)
+	if err != nil {
+		return err
+	}
+	prevKeys := info.sortKeys()
+	if info == nil {
+		info = newPoolInfo(addr, height)
+	}
+	if height > info.LastUpdatedHeight {
+		info.LastUpdatedHeight = height
+	}
+	info.PrunedHeight = 0
+
+	info.TickCount = 0
+	for _, ts := range poolState.TickStates {
+		if ts.LiquidityNet.Sign() != 0 {
+			info.TickCount++
+		}
+	}
+	return putPoolInfo(batch, prevKeys, info)
+}
+
+// indexPool is the migration to version 4, it builds the index entry of the pool of a pool height key
+func indexPool(db KVStore, key, value []byte, batch KVBatch) error {
+	if len(key) != 22 || len(value) != 8 {
+		return fmt.Errorf("%w: pool height key %x", ErrWrongValueLen, key)
+	}
+	addr := common.BytesToAddress(key[2:22])
+	height := bytesToUint64(value)
+
+	info := newPoolInfo(addr, height)
+	info.LastUpdatedHeight = height
+
+	// the oldest current tick version is the earliest known height of the pool
+	from, to := currentTickHistoryRange(addr)
+	first, err := db.GetNext(from[:], to[:])
+	if err != nil {
+		return err
+	}
+	if first != nil {
+		info.FirstSeenHeight = bytesToUint64(first.K()[22:30])
+	}
+
+	r := &rocksDBWrap{db: db}
+	err = r.ScanTickStates(addr, MinTick, MaxTick, func(tickState *TickState) error {
+		if tickState.LiquidityNet.Sign() != 0 {
+			info.TickCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	eventFrom, eventTo := makeEventKey(addr, 0, 0), makeEventKey(addr, ^uint64(0), ^uint32(0))
+	err = db.Scan(eventFrom[:], eventTo[:], func(key, value []byte) error {
+		record := &EventRecord{}
+		if err := json.Unmarshal(value, record); err != nil {
+			return err
+		}
+		info.EventCounts[record.Type]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return putPoolInfo(batch, nil, info)
+}
+
+// sortPool is the migration to version 5, it writes the sort keys of an index entry
+func sortPool(db KVStore, key, value []byte, batch KVBatch) error {
+	info := &PoolInfo{}
+	if err := json.Unmarshal(value, info); err != nil {
+		return fmt.Errorf("decode pool info %x err: %w", key, err)
+	}
+	putPoolSortKeys(batch, info)
+	return nil
+}
+
+// poolSortKey is the value a sort orders by, descending for all but address
+func poolSortKey(sortBy string, info *PoolInfo) uint64 {
+	switch sortBy {
+	case PoolSortActivity:
+		return info.LastUpdatedHeight
+	case PoolSortTicks:
+		return uint64(max(info.TickCount, 0))
+	}
+	return 0
+}
+
+// the cursor is the position of the next pool: <sort key>-<address>
+func formatPoolCursor(sortBy string, info *PoolInfo) string {
+	return fmt.Sprintf("%d-%s", poolSortKey(sortBy, info), info.Address.Hex())
+}
+
+func parsePoolCursor(cursor string) (uint64, common.Address, error) {
+	keyStr, addrStr, ok := strings.Cut(cursor, "-")
+	if !ok || !common.IsHexAddress(addrStr) {
+		return 0, common.Address{}, ErrWrongCursor
+	}
+
+	key, err := strconv.ParseUint(keyStr, 10, 64)
+	if err != nil {
+		return 0, common.Address{}, ErrWrongCursor
+	}
+	return key, common.HexToAddress(addrStr), nil
+}
+
+// ListPools pages through the index on one snapshot. Address order is read in
+// key order, the other orders from their sort keys, so a page reads limit+1
+// keys and a cursor stays in place when pools move around it.
+func (r *rocksDBWrap) ListPools(query *PoolQuery) (*PoolPage, error) {
+	sortBy := query.Sort
+	if sortBy == "" {
+		sortBy = PoolSortAddress
+	}
+	if sortBy != PoolSortAddress && sortBy != PoolSortActivity && sortBy != PoolSortTicks {
+		return nil, fmt.Errorf("%w: %s", ErrWrongPoolSort, query.Sort)
+	}
+
+	var cursorKey uint64
+	var cursorAddr common.Address
+	if query.Cursor != "" {
+		var err error
+		if cursorKey, cursorAddr, err = parsePoolCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	v, release := r.view()
+	defer release()
+
+	var pools []*PoolInfo
+	var err error
+	if sortBy == PoolSortAddress {
+		pools, err = v.listPoolsByAddress(query.Cursor != "", cursorAddr, query.Limit+1)
+	} else {
+		pools, err = v.listPoolsBySortKey(sortBy, query.Cursor != "", cursorKey, cursorAddr, query.Limit+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page := &PoolPage{Pools: pools}
+	if len(pools) > query.Limit {
+		page.Pools = pools[:query.Limit]
+		page.NextCursor = formatPoolCursor(sortBy, pools[query.Limit])
+	}
+	return page, nil
+}
+
+func (r *rocksDBWrap) listPoolsByAddress(hasCursor bool, cursorAddr common.Address, n int) ([]*PoolInfo, error) {
+	from, to := makePoolInfoKey(common.Address{}), makePoolInfoKey(maxAddr)
+	if hasCursor {
+		from = makePoolInfoKey(cursorAddr)
+	}
+
+	pools := make([]*PoolInfo, 0)
+	err := r.db.Scan(from[:], to[:], func(key, value []byte) error {
+		info := &PoolInfo{}
+		if err := json.Unmarshal(value, info); err != nil {
+			return fmt.Errorf("decode pool info %x err: %w", key, err)
+		}
+		pools = append(pools, info)
+		if len(pools) == n {
+			return ErrStopScan
+		}
+		return nil
+	})
+	return pools, err
+}
+
+func (r *rocksDBWrap) listPoolsBySortKey(sortBy string, hasCursor bool, cursorKey uint64, cursorAddr common.Address, n int) ([]*PoolInfo, error) {
+	from, to := makePoolSortKey(sortBy, ^uint64(0), common.Address{}), makePoolSortKey(sortBy, 0, maxAddr)
+	if hasCursor {
+		from = makePoolSortKey(sortBy, cursorKey, cursorAddr)
+	}
+
+	var addrs []common.Address
+	err := r.db.Scan(from[:], to[:], func(key, value []byte) error {
+		addrs = append(addrs, common.BytesToAddress(key[10:30]))
+		if len(addrs) == n {
+			return ErrStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]*PoolInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		info, err := getPoolInfo(r.db, addr)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, fmt.Errorf("%s sort key of %s without pool info", sortBy, addr)
+		}
+		pools = append(pools, info)
+	}
+	return pools, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestPoolInfo(t *testing.T, db DB, addr common.Address) *PoolInfo {
+	info, err := getPoolInfo(db.(*rocksDBWrap).db, addr)
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	return info
+}
+
+func TestPoolIndex_ReactBlockEvent(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	addr := common.HexToAddress("0xc00000000000000000000000000000000000000c")
+	require.NoError(t, db.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(100),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: -60, LiquidityNet: big.NewInt(1000)},
+			{Tick: 60, LiquidityNet: big.NewInt(-1000)},
+		},
+	}))
+
+	info := getTestPoolInfo(t, db, addr)
+	require.Equal(t, uint64(100), info.FirstSeenHeight)
+	require.Equal(t, uint64(100), info.LastUpdatedHeight)
+	require.Equal(t, int64(2), info.TickCount)
+
+	// events are counted when they are not stored
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, nil, &EventReactorConf{CheckLiquidity: true})
+	mint := &Event{Address: addr, Type: EventTypeMint, TickLower: big.NewInt(-120), TickUpper: big.NewInt(120), Amount: big.NewInt(500)}
+	swap := &Event{Address: addr, Type: EventTypeSwap, Tick: big.NewInt(70), Liquidity: big.NewInt(500)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 101, Events: []*Event{mint, swap}}))
+
+	info = getTestPoolInfo(t, db, addr)
+	require.Equal(t, uint64(100), info.FirstSeenHeight)
+	require.Equal(t, uint64(101), info.LastUpdatedHeight)
+	require.Equal(t, int64(4), info.TickCount)
+	require.Equal(t, map[string]uint64{"mint": 1, "swap": 1}, info.EventCounts)
+
+	// burning the position clears its ticks
+	burn := &Event{Address: addr, Type: EventTypeBurn, TickLower: big.NewInt(-120), TickUpper: big.NewInt(120), Amount: big.NewInt(500)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 102, Events: []*Event{burn}}))
+
+	info = getTestPoolInfo(t, db, addr)
+	require.Equal(t, uint64(102), info.LastUpdatedHeight)
+	require.Equal(t, int64(2), info.TickCount)
+	require.Equal(t, map[string]uint64{"mint": 1, "swap": 1, "burn": 1}, info.EventCounts)
+
+	// a deleted pool stays in the index without ticks
+	require.NoError(t, db.DeletePoolState(addr))
+	info = getTestPoolInfo(t, db, addr)
+	require.Equal(t, uint64(102), info.LastUpdatedHeight)
+	require.Equal(t, int64(0), info.TickCount)
+
+	// every change moved the sort keys of the pool
+	require.Equal(t, []common.Address{addr}, listAllPools(t, db, PoolSortActivity, 10))
+	require.Equal(t, []common.Address{addr}, listAllPools(t, db, PoolSortTicks, 10))
+}
+
+func putTestPoolInfos(t *testing.T, db DB, infos []*PoolInfo) {
+	store := db.(*rocksDBWrap).db
+	batch := store.NewBatch()
+	defer batch.Destroy()
+	for _, info := range infos {
+		require.NoError(t, putPoolInfo(batch, nil, info))
+	}
+	require.NoError(t, store.WriteBatch(batch))
+}
+
+// listAllPools pages through ListPools and returns the addresses in page order
+func listAllPools(t *testing.T, db DB, sortBy string, limit int) []common.Address {
+	var addrs []common.Address
+	query := &PoolQuery{Sort: sortBy, Limit: limit}
+	for {
+		page, err := db.ListPools(query)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Pools), limit)
+		for _, info := range page.Pools {
+			addrs = append(addrs, info.Address)
+		}
+		if page.NextCursor == "" {
+			return addrs
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func TestListPools(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	page, err := db.ListPools(&PoolQuery{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Pools)
+	require.Empty(t, page.NextCursor)
+
+	addrs := make([]common.Address, 5)
+	var infos []*PoolInfo
+	for i := range addrs {
+		addrs[i] = common.HexToAddress(fmt.Sprintf("0x%040x", i+1))
+		info := newPoolInfo(addrs[i], 100)
+		info.LastUpdatedHeight = []uint64{105, 103, 105, 101, 104}[i]
+		info.TickCount = []int64{2, 8, 8, 0, 8}[i]
+		infos = append(infos, info)
+	}
+	putTestPoolInfos(t, db, infos)
+
+	for _, limit := range []int{1, 2, 5, 10} {
+		require.Equal(t, addrs, listAllPools(t, db, PoolSortAddress, limit), "limit %d", limit)
+		require.Equal(t, []common.Address{addrs[0], addrs[2], addrs[4], addrs[1], addrs[3]}, listAllPools(t, db, PoolSortActivity, limit), "limit %d", limit)
+		require.Equal(t, []common.Address{addrs[1], addrs[2], addrs[4], addrs[0], addrs[3]}, listAllPools(t, db, PoolSortTicks, limit), "limit %d", limit)
+	}
+
+	// a pool moving ahead of the cursor leaves the next pages as they were
+	page, err = db.ListPools(&PoolQuery{Sort: PoolSortActivity, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []*PoolInfo{infos[0], infos[2]}, page.Pools)
+
+	store := db.(*rocksDBWrap).db
+	batch := store.NewBatch()
+	prevKeys := infos[3].sortKeys()
+	infos[3].LastUpdatedHeight = 106
+	require.NoError(t, putPoolInfo(batch, prevKeys, infos[3]))
+	require.NoError(t, store.WriteBatch(batch))
+	batch.Destroy()
+
+	page, err = db.ListPools(&PoolQuery{Sort: PoolSortActivity, Cursor: page.NextCursor, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []*PoolInfo{infos[4], infos[1]}, page.Pools)
+	require.Equal(t, []common.Address{addrs[3], addrs[0], addrs[2], addrs[4], addrs[1]}, listAllPools(t, db, PoolSortActivity, 2))
+
+	_, err = db.ListPools(&PoolQuery{Sort: "volume", Limit: 10})
+	require.ErrorIs(t, err, ErrWrongPoolSort)
+
+	_, err = db.ListPools(&PoolQuery{Sort: PoolSortTicks, Cursor: "8", Limit: 10})
+	require.ErrorIs(t, err, ErrWrongCursor)
+}
+
+func TestPoolIndex_MigrateSchema(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+	store := db.(*rocksDBWrap).db
+
+	addr := common.HexToAddress("0xd00000000000000000000000000000000000000d")
+	require.NoError(t, db.SetHeight(addr, 120))
+	require.NoError(t, db.SetCurrentTickHistory(addr, 90, 0))
+	require.NoError(t, db.SetCurrentTickHistory(addr, 110, 60))
+	require.NoError(t, db.SetTickState(addr, &TickState{Tick: -60, LiquidityNet: big.NewInt(1000)}))
+	require.NoError(t, db.SetTickState(addr, &TickState{Tick: 0, LiquidityNet: big.NewInt(0)}))
+	require.NoError(t, db.SetTickState(addr, &TickState{Tick: 60, LiquidityNet: big.NewInt(-1000)}))
+	require.NoError(t, db.AddEvents(addr, []*EventRecord{
+		{Height: 90, LogIndex: 0, Type: "mint"},
+		{Height: 110, LogIndex: 3, Type: "swap"},
+		{Height: 110, LogIndex: 4, Type: "swap"},
+	}))
+
+	// a pool of a version 3 db has no index entry
+	require.NoError(t, store.Set(SchemaVersionKey, uint32ToBytes(3)))
+	require.NoError(t, MigrateSchema(store, Migrations, SchemaVersion))
+
+	version, _, err := GetSchemaVersion(store)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, version)
+
+	info := getTestPoolInfo(t, db, addr)
+	require.Equal(t, uint64(90), info.FirstSeenHeight)
+	require.Equal(t, uint64(120), info.LastUpdatedHeight)
+	require.Equal(t, int64(2), info.TickCount)
+	require.Equal(t, map[string]uint64{"mint": 1, "swap": 2}, info.EventCounts)
+
+	value, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"address":"0xd00000000000000000000000000000000000000d","firstSeenHeight":90,"lastUpdatedHeight":120,"tickCount":2,"eventCounts":{"mint":1,"swap":2}}`, string(value))
+}
+
+func TestPoolIndex_MigrateSortKeys(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+	store := db.(*rocksDBWrap).db
+
+	// a version 4 db has the index entries without their sort keys
+	addrs := []common.Address{
+		common.HexToAddress("0xe10000000000000000000000000000000000000e"),
+		common.HexToAddress("0xe20000000000000000000000000000000000000e"),
+	}
+	for i, addr := range addrs {
+		info := newPoolInfo(addr, 100)
+		info.LastUpdatedHeight = uint64(110 + i)
+		info.TickCount = int64(4 - i)
+		value, err := json.Marshal(info)
+		require.NoError(t, err)
+		key := makePoolInfoKey(addr)
+		require.NoError(t, store.Set(key[:], value))
+	}
+	require.NoError(t, store.Set(SchemaVersionKey, uint32ToBytes(4)))
+
+	page, err := db.ListPools(&PoolQuery{Sort: PoolSortActivity, Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Pools)
+
+	require.NoError(t, MigrateSchema(store, Migrations, SchemaVersion))
+	require.Equal(t, []common.Address{addrs[1], addrs[0]}, listAllPools(t, db, PoolSortActivity, 1))
+	require.Equal(t, []common.Address{addrs[0], addrs[1]}, listAllPools(t, db, PoolSortTicks, 1))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// reasons a pool is pruned
+const (
+	PruneInactive     = "inactive"
+	PruneLowLiquidity = "low_liquidity"
+	PruneFiltered     = "filtered"
+)
+
+// PoolPruner removes the pools matching the pruning policy of EventReactorConf
+// through the pool index, one page of pools per step
+type PoolPruner struct {
+	db           DB
+	pairs        PairCache // nil skips the filtered check
+	conf         *EventReactorConf
+	minLiquidity *big.Int // nil skips the liquidity check
+}
+
+// NewPoolPruner returns nil when the policy prunes nothing
+func NewPoolPruner(db DB, pairs PairCache, conf *EventReactorConf) (*PoolPruner, error) {
+	p := &PoolPruner{db: db, pairs: pairs, conf: conf}
+
+	if conf.PoolMinLiquidity != "" {
+		minLiquidity, ok := new(big.Int).SetString(conf.PoolMinLiquidity, 10)
+		if !ok || minLiquidity.Sign() < 0 {
+			return nil, fmt.Errorf("invalid pool_min_liquidity: %s", conf.PoolMinLiquidity)
+		}
+		if minLiquidity.Sign() > 0 {
+			p.minLiquidity = minLiquidity
+		}
+	}
+
+	if conf.PoolRetention == 0 && p.minLiquidity == nil && !(conf.PruneFilteredPools && pairs != nil) {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// Due reports whether a run starts after the block at height
+func (p *PoolPruner) Due(height uint64) bool {
+	return p.conf.PoolPruneInterval != 0 && height%p.conf.PoolPruneInterval == 0
+}
+
+// Step checks the page of pools after cursor and prunes the matching ones at
+// height, it returns the cursor of the next page, empty after the last one
+func (p *PoolPruner) Step(cursor string, height uint64) (string, int, error) {
+	limit := p.conf.PoolPruneBatch
+	if limit <= 0 {
+		limit = defaultPoolLimit
+	}
+
+	page, err := p.db.ListPools(&PoolQuery{Sort: PoolSortAddress, Cursor: cursor, Limit: limit})
+	if err != nil {
+		return "", 0, err
+	}
+
+	pruned := 0
+	for _, info := range page.Pools {
+		reason, err := p.check(info, height)
+		if err != nil {
+			return "", pruned, err
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err = p.db.PrunePool(info.Address, height); err != nil {
+			return "", pruned, err
+		}
+		pruned++
+		Log.Debug("pool pruned", zap.String("addr", info.Address.String()), zap.String("reason", reason), zap.Uint64("lastUpdated", info.LastUpdatedHeight))
+	}
+	return page.NextCursor, pruned, nil
+}
+
+// check returns why the pool is pruned, empty to keep it. Pools without state,
+// pruned or quarantined, are kept as they are.
+func (p *PoolPruner) check(info *PoolInfo, height uint64) (string, error) {
+	poolHeight, err := p.db.GetHeight(info.Address)
+	if err != nil || poolHeight == 0 {
+		return "", err
+	}
+
+	if p.conf.PoolRetention != 0 && height >= info.LastUpdatedHeight+p.conf.PoolRetention {
+		return PruneInactive, nil
+	}
+
+	if p.conf.PruneFilteredPools && p.pairs != nil && p.filtered(info.Address) {
+		return PruneFiltered, nil
+	}
+
+	if p.minLiquidity != nil {
+		enough, err := p.hasLiquidity(info.Address)
+		if err != nil {
+			return "", err
+		}
+		if !enough {
+			return PruneLowLiquidity, nil
+		}
+	}
+
+	return "", nil
+}
+
+// hasLiquidity reports whether the liquidity of the pool reaches minLiquidity at
+// any price, in range or not. The liquidity between two ticks is the running sum
+// of liquidityNet from MinTick, the active liquidity at the current tick is one of
+// them. Positions apart from each other never add up at one price and adjacent
+// ones net out at the tick they share, the largest running sum counts both right.
+func (p *PoolPruner) hasLiquidity(addr common.Address) (bool, error) {
+	liquidity := new(big.Int)
+	enough := false
+	err := p.db.ScanTickStates(addr, MinTick, MaxTick, func(tickState *TickState) error {
+		liquidity.Add(liquidity, tickState.LiquidityNet)
+		if liquidity.Cmp(p.minLiquidity) >= 0 {
+			enough = true
+			return ErrStopScan
+		}
+		return nil
+	})
+	return enough, err
+}
+
+func (p *PoolPruner) filtered(addr common.Address) bool {
+	pair, ok := p.pairs.GetPair(addr)
+	return ok && pair.Filtered
+}
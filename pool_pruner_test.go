@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+type testPairCache map[common.Address]*Pair
+
+func (c testPairCache) GetPair(addr common.Address) (*Pair, bool) {
+	pair, ok := c[addr]
+	return pair, ok
+}
+
+func setTestPool(t *testing.T, db DB, addr common.Address, height uint64, liquidity int64) {
+	require.NoError(t, db.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      new(big.Int).SetUint64(height),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: -60, LiquidityNet: big.NewInt(liquidity)},
+			{Tick: 60, LiquidityNet: big.NewInt(-liquidity)},
+		},
+	}))
+}
+
+func TestNewPoolPruner(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	p, err := NewPoolPruner(db, testPairCache{}, &EventReactorConf{PoolPruneInterval: 10})
+	require.NoError(t, err)
+	require.Nil(t, p, "no policy")
+
+	p, err = NewPoolPruner(db, nil, &EventReactorConf{PruneFilteredPools: true, PoolPruneInterval: 10})
+	require.NoError(t, err)
+	require.Nil(t, p, "no pair cache to check")
+
+	_, err = NewPoolPruner(db, nil, &EventReactorConf{PoolMinLiquidity: "1e18"})
+	require.Error(t, err)
+
+	p, err = NewPoolPruner(db, nil, &EventReactorConf{PoolMinLiquidity: "1000", PoolPruneInterval: 10})
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	require.True(t, p.Due(20))
+	require.False(t, p.Due(21))
+}
+
+func TestPoolPruner_Step(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	active := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	inactive := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	shallow := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	filtered := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	outOfRange := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	setTestPool(t, db, active, 250, 1000)
+	setTestPool(t, db, inactive, 100, 1000)
+	setTestPool(t, db, shallow, 250, 100)
+	setTestPool(t, db, filtered, 250, 1000)
+
+	// no liquidity at the current tick, a deep position above it
+	require.NoError(t, db.SetPoolState(outOfRange, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(250),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: 600, LiquidityNet: big.NewInt(1000000)},
+			{Tick: 1200, LiquidityNet: big.NewInt(-1000000)},
+		},
+	}))
+	liquidity, err := db.GetActiveLiquidity(outOfRange)
+	require.NoError(t, err)
+	require.Zero(t, liquidity.Sign())
+
+	pairs := testPairCache{active: &Pair{}, filtered: &Pair{Filtered: true}, outOfRange: &Pair{}}
+	p, err := NewPoolPruner(db, pairs, &EventReactorConf{
+		PoolRetention:      100,
+		PoolMinLiquidity:   "500",
+		PruneFilteredPools: true,
+		PoolPruneInterval:  100,
+		PoolPruneBatch:     1,
+	})
+	require.NoError(t, err)
+
+	// one pool per step
+	cursor, steps, total := "", 0, 0
+	for {
+		next, pruned, err := p.Step(cursor, 300)
+		require.NoError(t, err)
+		steps++
+		total += pruned
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, 5, steps)
+	require.Equal(t, 3, total)
+
+	for _, addr := range []common.Address{active, outOfRange} {
+		poolState, err := db.GetPoolState(addr)
+		require.NoError(t, err)
+		require.NotNil(t, poolState, addr.Hex())
+		require.Zero(t, getTestPoolInfo(t, db, addr).PrunedHeight, addr.Hex())
+	}
+
+	for _, addr := range []common.Address{inactive, shallow, filtered} {
+		poolState, err := db.GetPoolState(addr)
+		require.NoError(t, err)
+		require.Nil(t, poolState, addr.Hex())
+
+		info := getTestPoolInfo(t, db, addr)
+		require.Equal(t, uint64(300), info.PrunedHeight, addr.Hex())
+		require.Equal(t, int64(0), info.TickCount, addr.Hex())
+	}
+
+	// tombstoned pools are not pruned again
+	_, pruned, err := p.Step("", 340)
+	require.NoError(t, err)
+	require.Equal(t, 0, pruned)
+	require.Equal(t, uint64(300), getTestPoolInfo(t, db, inactive).PrunedHeight)
+
+	// bootstrapping a pruned pool again clears its tombstone and keeps its history in the index
+	setTestPool(t, db, inactive, 410, 1000)
+	info := getTestPoolInfo(t, db, inactive)
+	require.Zero(t, info.PrunedHeight)
+	require.Equal(t, uint64(100), info.FirstSeenHeight)
+	require.Equal(t, uint64(410), info.LastUpdatedHeight)
+	require.Equal(t, int64(2), info.TickCount)
+}
+
+func TestPoolPruner_HasLiquidity(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	p, err := NewPoolPruner(db, nil, &EventReactorConf{PoolMinLiquidity: "500"})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name  string
+		ticks []*TickState
+		want  bool
+	}{
+		// [0, 600] and [600, 1200], the liquidityNet at 600 is 0
+		{"adjacent", []*TickState{
+			{Tick: 0, LiquidityNet: big.NewInt(500)},
+			{Tick: 1200, LiquidityNet: big.NewInt(-500)},
+		}, true},
+		// [0, 600] and [1200, 1800] never add up
+		{"apart", []*TickState{
+			{Tick: 0, LiquidityNet: big.NewInt(300)},
+			{Tick: 600, LiquidityNet: big.NewInt(-300)},
+			{Tick: 1200, LiquidityNet: big.NewInt(300)},
+			{Tick: 1800, LiquidityNet: big.NewInt(-300)},
+		}, false},
+		// [-600, 1200] and [0, 600] overlap in [0, 600]
+		{"overlapping", []*TickState{
+			{Tick: -600, LiquidityNet: big.NewInt(300)},
+			{Tick: 0, LiquidityNet: big.NewInt(200)},
+			{Tick: 600, LiquidityNet: big.NewInt(-200)},
+			{Tick: 1200, LiquidityNet: big.NewInt(-300)},
+		}, true},
+	} {
+		addr := common.BytesToAddress([]byte(test.name))
+		require.NoError(t, db.SetPoolState(addr, &PoolState{
+			Global: &PoolGlobalState{
+				Height:      big.NewInt(100),
+				TickSpacing: big.NewInt(60),
+				Tick:        big.NewInt(-1200),
+			},
+			TickStates: test.ticks,
+		}))
+
+		enough, err := p.hasLiquidity(addr)
+		require.NoError(t, err)
+		require.Equal(t, test.want, enough, test.name)
+	}
+}
+
+func TestReactBlockEvent_PrunePools(t *testing.T) {
+	db := newTestRepo(t)
+	defer db.Close()
+
+	stale := common.HexToAddress("0x5000000000000000000000000000000000000005")
+	fresh := common.HexToAddress("0x6000000000000000000000000000000000000006")
+	setTestPool(t, db, stale, 100, 1000)
+	setTestPool(t, db, fresh, 100, 1000)
+
+	conf := &EventReactorConf{CheckLiquidity: true, PoolRetention: 15, PoolPruneInterval: 10, PoolPruneBatch: 1}
+	p, err := NewPoolPruner(db, nil, conf)
+	require.NoError(t, err)
+
+	reactor := NewEventReactor(&sync.WaitGroup{}, db, nil, p, conf)
+
+	swap := &Event{Address: fresh, Type: EventTypeSwap, Tick: big.NewInt(10), Liquidity: big.NewInt(1000)}
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 110, Events: []*Event{swap}}))
+	reactor.(*eventReactor).background.Wait()
+
+	// the run at 120 prunes the pool without events since 100
+	require.NoError(t, reactor.ReactBlockEvent(&BlockEvent{Height: 120}))
+	reactor.(*eventReactor).background.Wait()
+
+	poolState, err := db.GetPoolState(stale)
+	require.NoError(t, err)
+	require.Nil(t, poolState)
+	require.Equal(t, uint64(120), getTestPoolInfo(t, db, stale).PrunedHeight)
+
+	poolState, err = db.GetPoolState(fresh)
+	require.NoError(t, err)
+	require.NotNil(t, poolState)
+	require.Equal(t, uint64(110), getTestPoolInfo(t, db, fresh).LastUpdatedHeight)
+}
@@ -2,34 +2,61 @@ package main
 
 import (
 	"errors"
+
 	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
 )
 
 var (
 	ErrPairNotFound = errors.New("no pair info")
 	ErrPairFiltered = errors.New("pair is filtered")
 	ErrNotV3Pool    = errors.New("not a v3 pool")
+	ErrNoPoolState  = errors.New("no pool state at the height")
 )
 
 type PoolStateGetter interface {
 	GetPoolState(addr common.Address) (*PoolState, error)
+	GetPoolStates(addrs []common.Address) (map[common.Address]*PoolState, error)
+	GetPoolStateAt(addr common.Address, height uint64) (*PoolState, error)
+	GetHeightAt(timestamp uint64) (uint64, error)
+	GetPoolStateInRange(addr common.Address, tickOffset int32) (*PoolState, error)
 }
 
 type poolStateGetter struct {
 	cache          Cache
 	db             DB
 	contractCaller *ContractCaller
+	logReplayer    *LogReplayer
 }
 
-func NewPoolStateGetter(cache Cache, db DB, url string) PoolStateGetter {
-	contractCaller := NewContractCaller(url)
+// NewPoolStateGetter bootstraps unknown pools through the lens contract, or by
+// replaying pool logs when logReplayer is not nil. With neither, the getter is
+// read-only and unknown pools are ErrNoPoolState.
+func NewPoolStateGetter(cache Cache, db DB, contractCaller *ContractCaller, logReplayer *LogReplayer) PoolStateGetter {
 	return &poolStateGetter{
 		cache:          cache,
 		db:             db,
 		contractCaller: contractCaller,
+		logReplayer:    logReplayer,
 	}
 }
 
+// readOnly reports whether the getter has no way to bootstrap unknown pools
+func (g *poolStateGetter) readOnly() bool {
+	return g.contractCaller == nil && g.logReplayer == nil
+}
+
+// stampTime fills the block time of the state height when the block is indexed
+func (g *poolStateGetter) stampTime(poolState *PoolState) error {
+	timestamp, err := g.db.GetBlockTime(poolState.Global.Height.Uint64())
+	if err != nil {
+		return err
+	}
+
+	poolState.Global.Timestamp = timestamp
+	return nil
+}
+
 func decoratePoolState(poolState *PoolState, pair *Pair) *PoolState {
 	poolState.Token0 = &Token{
 		Symbol:   pair.Token0Core.Symbol,
@@ -46,7 +73,7 @@ func decoratePoolState(poolState *PoolState, pair *Pair) *PoolState {
 	return poolState
 }
 
-func (g *poolStateGetter) GetPoolState(addr common.Address) (*PoolState, error) {
+func (g *poolStateGetter) getPair(addr common.Address) (*Pair, error) {
 	pair, ok := g.cache.GetPair(addr)
 	if !ok {
 		return nil, ErrPairNotFound
@@ -60,16 +87,36 @@ func (g *poolStateGetter) GetPoolState(addr common.Address) (*PoolState, error)
 		return nil, ErrNotV3Pool
 	}
 
+	return pair, nil
+}
+
+func (g *poolStateGetter) GetPoolState(addr common.Address) (*PoolState, error) {
+	pair, err := g.getPair(addr)
+	if err != nil {
+		return nil, err
+	}
+
 	poolState, err := g.db.GetPoolState(addr)
 	if err != nil {
 		return nil, err
 	}
 
 	if poolState != nil {
+		if err = g.stampTime(poolState); err != nil {
+			return nil, err
+		}
 		return decoratePoolState(poolState, pair), nil
 	}
 
-	poolState, err = g.contractCaller.GetPoolState(addr)
+	if g.readOnly() {
+		return nil, ErrNoPoolState
+	}
+
+	if g.logReplayer != nil {
+		poolState, err = g.logReplayer.GetPoolState(addr, pair.Block)
+	} else {
+		poolState, err = g.contractCaller.GetPoolState(addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -81,3 +128,128 @@ func (g *poolStateGetter) GetPoolState(addr common.Address) (*PoolState, error)
 
 	return decoratePoolState(poolState, pair), nil
 }
+
+// GetPoolStates is the batch form of GetPoolState, pools missing in the db are
+// bootstrapped together. Pools that are not found, filtered or not v3 are omitted,
+// so are the pools whose bootstrap failed.
+func (g *poolStateGetter) GetPoolStates(addrs []common.Address) (map[common.Address]*PoolState, error) {
+	result := make(map[common.Address]*PoolState, len(addrs))
+	pairs := make(map[common.Address]*Pair, len(addrs))
+	var missing []common.Address
+
+	for _, addr := range addrs {
+		if _, ok := pairs[addr]; ok {
+			continue
+		}
+
+		pair, err := g.getPair(addr)
+		if err != nil {
+			continue
+		}
+		pairs[addr] = pair
+
+		poolState, err := g.db.GetPoolState(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if poolState != nil {
+			result[addr] = decoratePoolState(poolState, pair)
+			continue
+		}
+		missing = append(missing, addr)
+	}
+
+	if len(missing) == 0 || g.readOnly() {
+		return result, nil
+	}
+
+	var poolStates map[common.Address]*PoolState
+	if g.logReplayer != nil {
+		poolStates = make(map[common.Address]*PoolState, len(missing))
+		for _, addr := range missing {
+			poolState, err := g.logReplayer.GetPoolState(addr, pairs[addr].Block)
+			if err != nil {
+				Log.Warn("replay pool failed", zap.String("addr", addr.String()), zap.Error(err))
+				continue
+			}
+			poolStates[addr] = poolState
+		}
+	} else {
+		var err error
+		poolStates, err = g.contractCaller.GetPoolStates(missing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for addr, poolState := range poolStates {
+		if err := g.db.SetPoolState(addr, poolState); err != nil {
+			return nil, err
+		}
+		result[addr] = decoratePoolState(poolState, pairs[addr])
+	}
+
+	return result, nil
+}
+
+// GetPoolStateAt reads the pool state as of a past height from the tick history, pools are never bootstrapped here
+func (g *poolStateGetter) GetPoolStateAt(addr common.Address, height uint64) (*PoolState, error) {
+	pair, err := g.getPair(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	poolState, err := g.db.GetPoolStateAt(addr, height)
+	if err != nil {
+		return nil, err
+	}
+
+	if poolState == nil {
+		return nil, ErrNoPoolState
+	}
+
+	if err = g.stampTime(poolState); err != nil {
+		return nil, err
+	}
+
+	return decoratePoolState(poolState, pair), nil
+}
+
+func (g *poolStateGetter) GetHeightAt(timestamp uint64) (uint64, error) {
+	return g.db.GetHeightAt(timestamp)
+}
+
+// GetPoolStateInRange is GetPoolState with only the ticks of CalculateTickRange around the current tick
+func (g *poolStateGetter) GetPoolStateInRange(addr common.Address, tickOffset int32) (*PoolState, error) {
+	pair, err := g.getPair(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	poolState, err := g.db.GetPoolStateInRange(addr, tickOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	if poolState == nil {
+		// bootstrap the pool first
+		if _, err = g.GetPoolState(addr); err != nil {
+			return nil, err
+		}
+
+		if poolState, err = g.db.GetPoolStateInRange(addr, tickOffset); err != nil {
+			return nil, err
+		}
+
+		if poolState == nil {
+			return nil, ErrNoPoolState
+		}
+	}
+
+	if err = g.stampTime(poolState); err != nil {
+		return nil, err
+	}
+
+	return decoratePoolState(poolState, pair), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	CallTypeReceipts    = "receipts"
+	CallTypeHeader      = "header"
+	CallTypeEthCall     = "eth_call"
+	CallTypeGetLogs     = "get_logs"
+	CallTypeBlockNumber = "block_number"
+)
+
+const (
+	PriorityHigh = iota // live head, never starved by low priority calls
+	PriorityLow         // bulk bootstrap
+)
+
+const (
+	maxLimiterWait = 10 * time.Millisecond
+)
+
+// TokenBucket limits requests per second and requests in flight. Low priority
+// callers only get a slot when no high priority caller is waiting.
+type TokenBucket struct {
+	mu          sync.Mutex
+	rps         float64 // 0 means unlimited
+	burst       float64
+	tokens      float64
+	last        time.Time
+	maxInFlight int // 0 means unlimited
+	inFlight    int
+	waitingHigh int
+}
+
+func NewTokenBucket(conf *RateLimitConf) *TokenBucket {
+	burst := float64(conf.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rps:         conf.RPS,
+		burst:       burst,
+		tokens:      burst,
+		last:        time.Now(),
+		maxInFlight: conf.MaxInFlight,
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if b.rps <= 0 {
+		return
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rps)
+	b.last = now
+}
+
+// tryAcquire takes a slot or returns how long to wait before trying again
+func (b *TokenBucket) tryAcquire(priority int) (bool, time.Duration) {
+	now := time.Now()
+	b.refill(now)
+
+	if priority != PriorityHigh && b.waitingHigh > 0 {
+		return false, maxLimiterWait
+	}
+
+	if b.maxInFlight > 0 && b.inFlight >= b.maxInFlight {
+		return false, maxLimiterWait
+	}
+
+	if b.rps > 0 && b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / b.rps * float64(time.Second))
+		return false, min(wait, maxLimiterWait)
+	}
+
+	if b.rps > 0 {
+		b.tokens--
+	}
+	b.inFlight++
+	return true, 0
+}
+
+func (b *TokenBucket) Acquire(ctx context.Context, priority int) error {
+	waiting := false
+	defer func() {
+		if waiting {
+			b.mu.Lock()
+			b.waitingHigh--
+			b.mu.Unlock()
+		}
+	}()
+
+	for {
+		b.mu.Lock()
+		ok, wait := b.tryAcquire(priority)
+		if !ok && !waiting && priority == PriorityHigh {
+			waiting = true
+			b.waitingHigh++
+		}
+		b.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (b *TokenBucket) Release() {
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+}
+
+// RateLimiter holds the limit of one RPC endpoint and the limits of each call type on it
+type RateLimiter struct {
+	endpoint  *TokenBucket
+	callTypes map[string]*TokenBucket
+}
+
+func NewRateLimiter(conf *EndpointRateLimitConf) *RateLimiter {
+	l := &RateLimiter{
+		endpoint:  NewTokenBucket(&conf.RateLimitConf),
+		callTypes: make(map[string]*TokenBucket),
+	}
+	for callType, callConf := range conf.Calls {
+		l.callTypes[callType] = NewTokenBucket(callConf)
+	}
+	return l
+}
+
+var (
+	rateLimiters   = make(map[string]*RateLimiter)
+	rateLimitersMu sync.Mutex
+)
+
+// GetRateLimiter returns the limiter of the endpoint shared by all its callers
+func GetRateLimiter(endpoint string) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	limiter, ok := rateLimiters[endpoint]
+	if !ok {
+		conf, ok := G.RateLimit.Endpoints[endpoint]
+		if !ok {
+			conf = G.RateLimit.Default
+		}
+		limiter = NewRateLimiter(conf)
+		rateLimiters[endpoint] = limiter
+	}
+	return limiter
+}
+
+// Acquire blocks until a request of callType may be sent, the returned func must be called when it is done
+func (l *RateLimiter) Acquire(ctx context.Context, callType string, priority int) (func(), error) {
+	callTypeBucket := l.callTypes[callType]
+	if callTypeBucket != nil {
+		if err := callTypeBucket.Acquire(ctx, priority); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.endpoint.Acquire(ctx, priority); err != nil {
+		if callTypeBucket != nil {
+			callTypeBucket.Release()
+		}
+		return nil, err
+	}
+
+	return func() {
+		l.endpoint.Release()
+		if callTypeBucket != nil {
+			callTypeBucket.Release()
+		}
+	}, nil
+}
+
+// RateLimited runs fn once a slot of callType is acquired
+func RateLimited[T any](ctx context.Context, l *RateLimiter, callType string, priority int, fn func(ctx context.Context) (T, error)) (T, error) {
+	release, err := l.Acquire(ctx, callType, priority)
+	if err != nil {
+		var empty T
+		return empty, err
+	}
+	defer release()
+	return fn(ctx)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket_RPS(t *testing.T) {
+	b := NewTokenBucket(&RateLimitConf{RPS: 100, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, b.Acquire(context.Background(), PriorityHigh))
+		b.Release()
+	}
+	require.GreaterOrEqual(t, time.Since(start), 45*time.Millisecond)
+}
+
+func TestTokenBucket_MaxInFlight(t *testing.T) {
+	b := NewTokenBucket(&RateLimitConf{MaxInFlight: 1})
+	require.NoError(t, b.Acquire(context.Background(), PriorityHigh))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.Acquire(ctx, PriorityHigh), context.DeadlineExceeded)
+
+	b.Release()
+	require.NoError(t, b.Acquire(context.Background(), PriorityHigh))
+}
+
+func TestTokenBucket_Priority(t *testing.T) {
+	b := NewTokenBucket(&RateLimitConf{MaxInFlight: 1})
+	require.NoError(t, b.Acquire(context.Background(), PriorityLow))
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	acquire := func(priority int) {
+		defer wg.Done()
+		require.NoError(t, b.Acquire(context.Background(), priority))
+		mu.Lock()
+		order = append(order, priority)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		b.Release()
+	}
+
+	wg.Add(2)
+	go acquire(PriorityLow)
+	time.Sleep(20 * time.Millisecond)
+	go acquire(PriorityHigh)
+	time.Sleep(20 * time.Millisecond)
+
+	b.Release()
+	wg.Wait()
+	require.Equal(t, []int{PriorityHigh, PriorityLow}, order)
+}
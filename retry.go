@@ -1,11 +1,216 @@
 package main
 
 import (
-	"github.com/avast/retry-go/v4"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.uber.org/zap"
 )
 
 var (
-	infiniteAttempts = retry.Attempts(0)
-	retryDelay       = retry.Delay(100 * time.Microsecond)
+	ErrRPCRetryable = errors.New("retryable rpc error")
+	ErrRPCPermanent = errors.New("permanent rpc error")
 )
+
+// RPCError is a classified JSON-RPC or transport error. Code is the JSON-RPC
+// error code or the HTTP status code, 0 for transport errors.
+type RPCError struct {
+	Code      int
+	Retryable bool
+	Err       error
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error(code=%d, retryable=%v): %v", e.Code, e.Retryable, e.Err)
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RPCError) Is(target error) bool {
+	if e.Retryable {
+		return target == ErrRPCRetryable
+	}
+	return target == ErrRPCPermanent
+}
+
+var (
+	permanentErrMsgs = []string{
+		"execution reverted",
+		"out of gas",
+		"abi: cannot marshal in to go slice",
+	}
+)
+
+func hasPermanentErrMsg(err error) bool {
+	errMsg := err.Error()
+	for _, msg := range permanentErrMsgs {
+		if strings.Contains(errMsg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyRPCError wraps err into a *RPCError telling whether the call is worth retrying
+func ClassifyRPCError(err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+
+	var (
+		jsonErr rpc.Error
+		httpErr rpc.HTTPError
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return &RPCError{Retryable: false, Err: err}
+
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ethereum.NotFound):
+		return &RPCError{Retryable: true, Err: err}
+
+	case errors.As(err, &httpErr):
+		retryable := httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+		return &RPCError{Code: httpErr.StatusCode, Retryable: retryable, Err: err}
+
+	case errors.As(err, &jsonErr):
+		code := jsonErr.ErrorCode()
+		switch code {
+		case 3, -32700, -32600, -32601, -32602: // reverted, parse error, invalid request, method not found, invalid params
+			return &RPCError{Code: code, Retryable: false, Err: err}
+		default: // -32000 server error, -32005 limit exceeded, -32603 internal error ...
+			return &RPCError{Code: code, Retryable: !hasPermanentErrMsg(err), Err: err}
+		}
+
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &RPCError{Retryable: true, Err: err}
+
+	default:
+		return &RPCError{Retryable: !hasPermanentErrMsg(err), Err: err}
+	}
+}
+
+func IsRetryableErr(err error) bool {
+	return ClassifyRPCError(err).Retryable
+}
+
+// RetryPolicy is shared by all callers of one RPC endpoint: exponential backoff
+// with jitter, an optional time budget per call and a circuit breaker.
+type RetryPolicy struct {
+	endpoint     string
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	breaker      *CircuitBreaker
+
+	calls    atomic.Uint64
+	retries  atomic.Uint64
+	failures atomic.Uint64
+}
+
+type RetryStats struct {
+	Endpoint string
+	Calls    uint64
+	Retries  uint64
+	Failures uint64
+}
+
+func NewRetryPolicy(endpoint string, conf *RetryConf) *RetryPolicy {
+	return &RetryPolicy{
+		endpoint:     endpoint,
+		initialDelay: time.Duration(conf.InitialDelayMs) * time.Millisecond,
+		maxDelay:     time.Duration(conf.MaxDelayMs) * time.Millisecond,
+		breaker:      NewCircuitBreaker(endpoint, conf.BreakerThreshold, time.Duration(conf.BreakerCooldownMs)*time.Millisecond),
+	}
+}
+
+var (
+	retryPolicies   = make(map[string]*RetryPolicy)
+	retryPoliciesMu sync.Mutex
+)
+
+// GetRetryPolicy returns the policy of the endpoint, so that every component
+// calling the same endpoint shares its circuit breaker and stats
+func GetRetryPolicy(endpoint string) *RetryPolicy {
+	retryPoliciesMu.Lock()
+	defer retryPoliciesMu.Unlock()
+
+	policy, ok := retryPolicies[endpoint]
+	if !ok {
+		policy = NewRetryPolicy(endpoint, G.Retry)
+		retryPolicies[endpoint] = policy
+	}
+	return policy
+}
+
+func (p *RetryPolicy) Stats() RetryStats {
+	return RetryStats{
+		Endpoint: p.endpoint,
+		Calls:    p.calls.Load(),
+		Retries:  p.retries.Load(),
+		Failures: p.failures.Load(),
+	}
+}
+
+// DoWithRetry calls fn until it succeeds, fails with a permanent error or the
+// budget is used up. A zero budget retries until ctx is done.
+func DoWithRetry[T any](ctx context.Context, p *RetryPolicy, budget time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	p.calls.Add(1)
+	t, err := retry.DoWithData(func() (T, error) {
+		var empty T
+		if err := p.breaker.Allow(); err != nil {
+			return empty, err
+		}
+
+		t, err := fn(ctx)
+		if err == nil {
+			p.breaker.Success()
+			return t, nil
+		}
+
+		// permanent errors are the caller's, like a canceled ctx or a revert,
+		// they neither close nor open the breaker
+		rpcErr := ClassifyRPCError(err)
+		if rpcErr.Retryable {
+			p.breaker.Failure()
+		} else {
+			p.breaker.Release()
+		}
+		return empty, rpcErr
+	},
+		retry.Attempts(0),
+		retry.Context(ctx),
+		retry.Delay(p.initialDelay),
+		retry.MaxDelay(p.maxDelay),
+		retry.MaxJitter(p.initialDelay),
+		retry.DelayType(retry.CombineDelay(retry.BackOffDelay, retry.RandomDelay)),
+		retry.RetryIf(IsRetryableErr),
+		retry.WrapContextErrorWithLastError(true),
+		retry.OnRetry(func(n uint, err error) {
+			retries := p.retries.Add(1)
+			Log.Warn("rpc retry", zap.String("endpoint", p.endpoint), zap.Uint("attempt", n+1), zap.Uint64("totalRetries", retries), zap.Error(err))
+		}),
+	)
+	if err != nil {
+		p.failures.Add(1)
+	}
+	return t, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type testJsonRPCError struct {
+	code int
+	msg  string
+}
+
+func (e *testJsonRPCError) Error() string  { return e.msg }
+func (e *testJsonRPCError) ErrorCode() int { return e.code }
+
+func TestClassifyRPCError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &testJsonRPCError{code: 3, msg: "execution reverted"}, retryable: false},
+		{err: &testJsonRPCError{code: -32601, msg: "method not found"}, retryable: false},
+		{err: &testJsonRPCError{code: -32005, msg: "limit exceeded"}, retryable: true},
+		{err: &testJsonRPCError{code: -32000, msg: "header not found"}, retryable: true},
+		{err: &testJsonRPCError{code: -32000, msg: "out of gas"}, retryable: false},
+		{err: rpc.HTTPError{StatusCode: 429}, retryable: true},
+		{err: rpc.HTTPError{StatusCode: 503}, retryable: true},
+		{err: rpc.HTTPError{StatusCode: 401}, retryable: false},
+		{err: io.EOF, retryable: true},
+		{err: context.Canceled, retryable: false},
+		{err: ErrCircuitOpen, retryable: true},
+	}
+
+	for _, test := range tests {
+		rpcErr := ClassifyRPCError(test.err)
+		require.Equal(t, test.retryable, rpcErr.Retryable, test.err.Error())
+		require.Equal(t, test.err, rpcErr.Err)
+		if test.retryable {
+			require.ErrorIs(t, rpcErr, ErrRPCRetryable)
+		} else {
+			require.ErrorIs(t, rpcErr, ErrRPCPermanent)
+		}
+	}
+}
+
+func newTestRetryPolicy() *RetryPolicy {
+	return NewRetryPolicy("test", &RetryConf{
+		InitialDelayMs:    1,
+		MaxDelayMs:        5,
+		BreakerThreshold:  10,
+		BreakerCooldownMs: 10,
+	})
+}
+
+func TestDoWithRetry(t *testing.T) {
+	policy := newTestRetryPolicy()
+
+	calls := 0
+	v, err := DoWithRetry(context.Background(), policy, 0, func(ctx context.Context) (int, error) {
+		calls++
+		if calls < 5 {
+			return 0, io.EOF
+		}
+		return 42, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 42, v)
+	require.Equal(t, uint64(4), policy.Stats().Retries)
+
+	calls = 0
+	_, err = DoWithRetry(context.Background(), policy, 0, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, &testJsonRPCError{code: 3, msg: "execution reverted"}
+	})
+	require.ErrorIs(t, err, ErrRPCPermanent)
+	require.Equal(t, 1, calls, "permanent errors should not be retried")
+
+	_, err = DoWithRetry(context.Background(), policy, 50*time.Millisecond, func(ctx context.Context) (int, error) {
+		return 0, io.EOF
+	})
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Equal(t, uint64(2), policy.Stats().Failures)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker("test", 2, 20*time.Millisecond)
+	require.NoError(t, b.Allow())
+	b.Failure()
+	require.NoError(t, b.Allow())
+	b.Failure()
+	require.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.Allow(), "trial call after cooldown")
+	require.ErrorIs(t, b.Allow(), ErrCircuitOpen, "only one trial call")
+	b.Success()
+	require.NoError(t, b.Allow())
+
+	b.Failure()
+	b.Failure()
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.Allow(), "trial call after cooldown")
+	b.Release()
+	require.NoError(t, b.Allow(), "a released trial call lets the next one through")
+}
+
+func TestDoWithRetry_Breaker(t *testing.T) {
+	policy := NewRetryPolicy("test", &RetryConf{
+		InitialDelayMs:    1,
+		MaxDelayMs:        5,
+		BreakerThreshold:  2,
+		BreakerCooldownMs: 20,
+	})
+
+	// a permanent error does not reset the failures before it
+	calls := 0
+	_, err := DoWithRetry(context.Background(), policy, 0, func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, io.EOF
+		}
+		return 0, &testJsonRPCError{code: 3, msg: "execution reverted"}
+	})
+	require.ErrorIs(t, err, ErrRPCPermanent)
+	require.Equal(t, 1, policy.breaker.failures)
+
+	_, err = DoWithRetry(context.Background(), policy, 0, func(ctx context.Context) (int, error) {
+		return 0, context.Canceled
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, policy.breaker.failures)
+
+	// a canceled trial call leaves the breaker open for the next trial
+	policy.breaker.Failure()
+	require.ErrorIs(t, policy.breaker.Allow(), ErrCircuitOpen)
+	time.Sleep(30 * time.Millisecond)
+	_, err = DoWithRetry(context.Background(), policy, 0, func(ctx context.Context) (int, error) {
+		return 0, context.Canceled
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	v, err := DoWithRetry(context.Background(), policy, 50*time.Millisecond, func(ctx context.Context) (int, error) {
+		return 42, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 42, v)
+	require.Equal(t, 0, policy.breaker.failures)
+}
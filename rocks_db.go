@@ -1,44 +1,38 @@
+//go:build !purego
+
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/linxGnu/grocksdb"
 )
 
-type EntryK interface {
-	K() []byte
-}
-
-type EntryV interface {
-	V() []byte
-}
-type KVEntry interface {
-	EntryK
-	EntryV
-}
+// DefaultBackend is used when the config names no backend
+const DefaultBackend = BackendRocksDB
 
-type bytesEntry struct {
-	key []byte
-	val []byte
-}
+type RocksDB struct {
+	db  *grocksdb.DB
+	ro  *grocksdb.ReadOptions
+	wo  *grocksdb.WriteOptions
+	cfs map[string]*grocksdb.ColumnFamilyHandle
 
-func (e *bytesEntry) K() []byte { return e.key }
-func (e *bytesEntry) V() []byte { return e.val }
+	// view is set on the views returned by Snapshot, they pin snapshot. A
+	// secondary rejects snapshots, its views only hold off catchUp.
+	view     bool
+	snapshot *grocksdb.Snapshot
 
-type RocksDBOptions struct {
-	EnableLog            bool
-	BlockCacheSize       uint64
-	WriteBufferSize      uint64
-	MaxWriteBufferNumber int
-}
+	// catchUp keeps a secondary from catching up while a view or a scan is open
+	catchUp   *sync.RWMutex
+	secondary bool
 
-type RocksDB struct {
-	db *grocksdb.DB
-	ro *grocksdb.ReadOptions
-	wo *grocksdb.WriteOptions
+	// beforeClose are run by Close before the db is closed
+	beforeClose []func()
 }
 
 func logRocksDBStats(db *grocksdb.DB) {
@@ -55,16 +49,24 @@ func logRocksDBStats(db *grocksdb.DB) {
 	fmt.Printf("BlockCache Hit: %d, Miss: %d, HitRate: %.2f%%\n", blockCacheHit, blockCacheMiss, hitRate)
 }
 
-func NewRocksDB(name string, optsConf *RocksDBOptions) (*RocksDB, error) {
-	opts := grocksdb.NewDefaultOptions()
-	opts.SetCreateIfMissing(true)
-
-	if optsConf.BlockCacheSize > 0 {
-		options := grocksdb.NewDefaultBlockBasedTableOptions()
-		cache := grocksdb.NewLRUCache(optsConf.BlockCacheSize)
+func newTableOptions(cache *grocksdb.Cache, bloom bool) *grocksdb.BlockBasedTableOptions {
+	options := grocksdb.NewDefaultBlockBasedTableOptions()
+	if cache != nil {
 		options.SetBlockCache(cache)
-		opts.SetBlockBasedTableFactory(options)
 	}
+	if bloom {
+		options.SetFilterPolicy(grocksdb.NewBloomFilter(bloomBitsPerKey))
+		options.SetWholeKeyFiltering(true)
+	}
+	return options
+}
+
+// newPoolScopedOptions is for the column families whose keys all start with prefix + pool address
+func newPoolScopedOptions(optsConf *RocksDBOptions, cache *grocksdb.Cache) *grocksdb.Options {
+	opts := grocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(newTableOptions(cache, true))
+	opts.SetPrefixExtractor(grocksdb.NewFixedPrefixTransform(PoolKeyPrefixLen))
+	opts.SetMemTablePrefixBloomSizeRatio(memtablePrefixBloomSizeRatio)
 
 	if optsConf.WriteBufferSize > 0 {
 		opts.SetWriteBufferSize(optsConf.WriteBufferSize)
@@ -72,8 +74,85 @@ func NewRocksDB(name string, optsConf *RocksDBOptions) (*RocksDB, error) {
 	if optsConf.MaxWriteBufferNumber > 0 {
 		opts.SetMaxWriteBufferNumber(optsConf.MaxWriteBufferNumber)
 	}
+	return opts
+}
+
+func newDBOptions(optsConf *RocksDBOptions) (*grocksdb.Options, []*grocksdb.Options) {
+	var cache *grocksdb.Cache
+	if optsConf.BlockCacheSize > 0 {
+		cache = grocksdb.NewLRUCache(optsConf.BlockCacheSize)
+	}
+
+	// the default column family only holds a few global keys
+	opts := grocksdb.NewDefaultOptions()
+	opts.SetCreateIfMissing(true)
+	opts.SetCreateIfMissingColumnFamilies(true)
+	opts.SetBlockBasedTableFactory(newTableOptions(cache, false))
+
+	cfOpts := []*grocksdb.Options{
+		opts,
+		newPoolScopedOptions(optsConf, cache),
+		newPoolScopedOptions(optsConf, cache),
+	}
+	return opts, cfOpts
+}
+
+func newRocksDB(db *grocksdb.DB, handles []*grocksdb.ColumnFamilyHandle) *RocksDB {
+	cfs := make(map[string]*grocksdb.ColumnFamilyHandle, len(handles))
+	for i, handle := range handles {
+		cfs[ColumnFamilies[i]] = handle
+	}
+
+	return &RocksDB{
+		db:      db,
+		ro:      grocksdb.NewDefaultReadOptions(),
+		wo:      grocksdb.NewDefaultWriteOptions(),
+		cfs:     cfs,
+		catchUp: &sync.RWMutex{},
+	}
+}
 
-	db, err := grocksdb.OpenDb(opts, name)
+// OpenRocksDBReadOnly opens an existing db for reads only, e.g. a checkpoint
+func OpenRocksDBReadOnly(name string) (*RocksDB, error) {
+	opts, cfOpts := newDBOptions(&RocksDBOptions{})
+	opts.SetCreateIfMissing(false)
+
+	db, handles, err := grocksdb.OpenDbForReadOnlyColumnFamilies(opts, name, ColumnFamilies, cfOpts, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RocksDB read-only: %v", err)
+	}
+
+	return newRocksDB(db, handles), nil
+}
+
+// OpenRocksDBSecondary opens the db at name as a secondary instance, which only
+// sees the primary's writes after CatchUp. secondaryPath keeps the instance's
+// own logs and must differ per instance.
+func OpenRocksDBSecondary(name string, secondaryPath string, optsConf *RocksDBOptions) (*RocksDB, error) {
+	opts, cfOpts := newDBOptions(optsConf)
+	opts.SetCreateIfMissing(false)
+	opts.SetMaxOpenFiles(-1)
+
+	db, handles, err := grocksdb.OpenDbAsSecondaryColumnFamilies(opts, name, secondaryPath, ColumnFamilies, cfOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RocksDB as secondary: %v", err)
+	}
+
+	r := newRocksDB(db, handles)
+	r.secondary = true
+	return r, nil
+}
+
+// CatchUp applies the primary's new writes to a secondary, after the open views are released
+func (r *RocksDB) CatchUp() error {
+	r.catchUp.Lock()
+	defer r.catchUp.Unlock()
+	return r.db.TryCatchUpWithPrimary()
+}
+
+func NewRocksDB(name string, optsConf *RocksDBOptions) (*RocksDB, error) {
+	opts, cfOpts := newDBOptions(optsConf)
+	db, handles, err := grocksdb.OpenDbColumnFamilies(opts, name, ColumnFamilies, cfOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open RocksDB: %v", err)
 	}
@@ -87,21 +166,77 @@ func NewRocksDB(name string, optsConf *RocksDBOptions) (*RocksDB, error) {
 		}()
 	}
 
-	return &RocksDB{
-		db: db,
-		ro: grocksdb.NewDefaultReadOptions(),
-		wo: grocksdb.NewDefaultWriteOptions(),
-	}, nil
+	return newRocksDB(db, handles), nil
+}
+
+// BeforeClose registers fn to run when the db is closed, before it is, for
+// whatever uses the db in the background
+func (r *RocksDB) BeforeClose(fn func()) {
+	r.beforeClose = append(r.beforeClose, fn)
 }
 
 func (r *RocksDB) Close() {
+	for _, fn := range r.beforeClose {
+		fn()
+	}
 	r.ro.Destroy()
 	r.wo.Destroy()
+	for _, handle := range r.cfs {
+		handle.Destroy()
+	}
 	r.db.Close()
 }
 
+// Snapshot returns a view whose reads all see the db as of now, release it when
+// done. Writes through the view go to the live db. A view returns itself.
+func (r *RocksDB) Snapshot() (KVStore, func()) {
+	if r.view {
+		return r, func() {}
+	}
+
+	r.catchUp.RLock()
+	if r.secondary {
+		view := &RocksDB{db: r.db, ro: r.ro, wo: r.wo, cfs: r.cfs, view: true, catchUp: r.catchUp, secondary: true}
+		return view, r.catchUp.RUnlock
+	}
+
+	snapshot := r.db.NewSnapshot()
+	ro := grocksdb.NewDefaultReadOptions()
+	ro.SetSnapshot(snapshot)
+
+	view := &RocksDB{db: r.db, ro: ro, wo: r.wo, cfs: r.cfs, view: true, snapshot: snapshot, catchUp: r.catchUp}
+	return view, func() {
+		ro.Destroy()
+		r.db.ReleaseSnapshot(snapshot)
+		r.catchUp.RUnlock()
+	}
+}
+
+func (r *RocksDB) cf(key []byte) *grocksdb.ColumnFamilyHandle {
+	return r.cfs[ColumnFamilyOf(key)]
+}
+
+// newScanOptions bounds a scan of [from, to] with an iterate upper bound, and
+// uses the prefix bloom filters when the range is inside one pool
+func (r *RocksDB) newScanOptions(from, to []byte) *grocksdb.ReadOptions {
+	ro := grocksdb.NewDefaultReadOptions()
+	if r.snapshot != nil {
+		ro.SetSnapshot(r.snapshot)
+	}
+	ro.SetIterateUpperBound(append(append([]byte{}, to...), 0))
+
+	if len(from) >= PoolKeyPrefixLen && len(to) >= PoolKeyPrefixLen &&
+		string(from[:PoolKeyPrefixLen]) == string(to[:PoolKeyPrefixLen]) &&
+		ColumnFamilyOf(from) != CFDefault {
+		ro.SetPrefixSameAsStart(true)
+	} else {
+		ro.SetTotalOrderSeek(true)
+	}
+	return ro
+}
+
 func (r *RocksDB) Get(key []byte) ([]byte, error) {
-	slice, err := r.db.Get(r.ro, key)
+	slice, err := r.db.GetCF(r.ro, r.cf(key), key)
 	if err != nil {
 		return nil, err
 	}
@@ -115,39 +250,184 @@ func (r *RocksDB) Get(key []byte) ([]byte, error) {
 }
 
 func (r *RocksDB) Set(key, value []byte) error {
-	return r.db.Put(r.wo, key, value)
+	return r.db.PutCF(r.wo, r.cf(key), key, value)
 }
 
 func (r *RocksDB) Del(key []byte) error {
-	return r.db.Delete(r.wo, key)
+	return r.db.DeleteCF(r.wo, r.cf(key), key)
 }
 
-func (r *RocksDB) GetRange(from, to []byte) ([]KVEntry, error) {
-	it := r.db.NewIterator(r.ro)
+// Scan calls fn for every entry of [from, to] in key order, on a snapshot pinned
+// for the whole scan, on a secondary with catch-up held off instead. key and value are only valid inside fn. Returning
+// ErrStopScan from fn ends the scan, other errors are passed through.
+func (r *RocksDB) Scan(from, to []byte, fn func(key, value []byte) error) error {
+	return r.scan(r.cf(from), from, to, fn)
+}
+
+// ScanCF is Scan in the named column family, regardless of the key prefix
+func (r *RocksDB) ScanCF(cf string, from, to []byte, fn func(key, value []byte) error) error {
+	return r.scan(r.cfs[cf], from, to, fn)
+}
+
+func (r *RocksDB) scan(cf *grocksdb.ColumnFamilyHandle, from, to []byte, fn func(key, value []byte) error) error {
+	ro := r.newScanOptions(from, to)
+	defer ro.Destroy()
+	switch {
+	case r.view:
+		// newScanOptions set the snapshot of the view
+	case r.secondary:
+		r.catchUp.RLock()
+		defer r.catchUp.RUnlock()
+	default:
+		snapshot := r.db.NewSnapshot()
+		defer r.db.ReleaseSnapshot(snapshot)
+		ro.SetSnapshot(snapshot)
+	}
+
+	it := r.db.NewIteratorCF(ro, cf)
 	defer it.Close()
 
-	var result []KVEntry
 	for it.Seek(from); it.Valid(); it.Next() {
 		key := it.Key()
-		keyData := append([]byte{}, key.Data()...)
-		if string(keyData) > string(to) {
-			key.Free()
-			break
-		}
-
 		value := it.Value()
-		valData := append([]byte{}, value.Data()...)
-		result = append(result, &bytesEntry{key: keyData, val: valData})
+		err := fn(key.Data(), value.Data())
 		key.Free()
 		value.Free()
+
+		if errors.Is(err, ErrStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := it.Err(); err != nil {
+	return it.Err()
+}
+
+func (r *RocksDB) GetRange(from, to []byte) ([]KVEntry, error) {
+	return r.getRangeLimit(r.cf(from), from, to, math.MaxInt)
+}
+
+// GetRangeLimit is GetRange returning at most limit entries
+func (r *RocksDB) GetRangeLimit(from, to []byte, limit int) ([]KVEntry, error) {
+	return r.getRangeLimit(r.cf(from), from, to, limit)
+}
+
+// GetRangeLimitCF is GetRangeLimit in the named column family, regardless of the key prefix
+func (r *RocksDB) GetRangeLimitCF(cf string, from, to []byte, limit int) ([]KVEntry, error) {
+	return r.getRangeLimit(r.cfs[cf], from, to, limit)
+}
+
+func (r *RocksDB) getRangeLimit(cf *grocksdb.ColumnFamilyHandle, from, to []byte, limit int) ([]KVEntry, error) {
+	var result []KVEntry
+	err := r.scan(cf, from, to, func(key, value []byte) error {
+		if len(result) == limit {
+			return ErrStopScan
+		}
+		result = append(result, &bytesEntry{key: append([]byte{}, key...), val: append([]byte{}, value...)})
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (r *RocksDB) WriteBatch(batch *grocksdb.WriteBatch) error {
-	return r.db.Write(r.wo, batch)
+// GetPrev returns the greatest entry with lowerBound <= key <= to, nil if there is none
+func (r *RocksDB) GetPrev(lowerBound, to []byte) (KVEntry, error) {
+	ro := r.newScanOptions(lowerBound, to)
+	defer ro.Destroy()
+	it := r.db.NewIteratorCF(ro, r.cf(to))
+	defer it.Close()
+
+	it.SeekForPrev(to)
+	if !it.Valid() {
+		return nil, it.Err()
+	}
+
+	key := it.Key()
+	defer key.Free()
+	if string(key.Data()) < string(lowerBound) {
+		return nil, nil
+	}
+
+	value := it.Value()
+	defer value.Free()
+	return &bytesEntry{key: append([]byte{}, key.Data()...), val: append([]byte{}, value.Data()...)}, nil
+}
+
+// GetNext returns the smallest entry with from <= key <= upperBound, nil if there is none
+func (r *RocksDB) GetNext(from, upperBound []byte) (KVEntry, error) {
+	entries, err := r.GetRangeLimit(from, upperBound, 1)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// Batch is a write batch that puts every key into its column family, or into
+// one pinned column family
+type Batch struct {
+	r      *RocksDB
+	wb     *grocksdb.WriteBatch
+	pinned *grocksdb.ColumnFamilyHandle
+}
+
+func (r *RocksDB) NewBatch() KVBatch {
+	return &Batch{r: r, wb: grocksdb.NewWriteBatch()}
+}
+
+// NewBatchCF returns a batch whose Put and Delete go to the named column family,
+// or to the column family of their prefix when cf is empty
+func (r *RocksDB) NewBatchCF(cf string) *Batch {
+	return &Batch{r: r, wb: grocksdb.NewWriteBatch(), pinned: r.cfs[cf]}
+}
+
+func (b *Batch) cf(key []byte) *grocksdb.ColumnFamilyHandle {
+	if b.pinned != nil {
+		return b.pinned
+	}
+	return b.r.cf(key)
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.wb.PutCF(b.cf(key), key, value)
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.wb.DeleteCF(b.cf(key), key)
+}
+
+// DeleteRange deletes [from, to)
+func (b *Batch) DeleteRange(from, to []byte) {
+	b.wb.DeleteRangeCF(b.cf(from), from, to)
+}
+
+func (b *Batch) PutCF(cf string, key, value []byte) {
+	b.wb.PutCF(b.r.cfs[cf], key, value)
+}
+
+func (b *Batch) DeleteCF(cf string, key []byte) {
+	b.wb.DeleteCF(b.r.cfs[cf], key)
+}
+
+func (b *Batch) Destroy() {
+	b.wb.Destroy()
+}
+
+func (r *RocksDB) WriteBatch(batch KVBatch) error {
+	return r.db.Write(r.wo, batch.(*Batch).wb)
+}
+
+// Checkpoint writes a consistent copy of the db into dir, which must not exist.
+// Memtables are flushed first, so the copy needs no WAL replay.
+func (r *RocksDB) Checkpoint(dir string) error {
+	checkpoint, err := r.db.NewCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Destroy()
+
+	return checkpoint.CreateCheckpoint(dir, 0)
 }
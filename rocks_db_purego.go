@@ -0,0 +1,61 @@
+//go:build purego
+
+package main
+
+import (
+	"errors"
+)
+
+// Built with -tags purego there is no cgo and no RocksDB: LevelDB is the only
+// backend and the RocksDB-only features fail with ErrNoRocksDB.
+
+const DefaultBackend = BackendLevelDB
+
+var (
+	ErrNoRocksDB = errors.New("built without rocksdb")
+)
+
+// RocksDB is never opened in a purego build
+type RocksDB struct {
+	KVStore
+}
+
+type Batch struct {
+	KVBatch
+}
+
+func NewRocksDB(name string, optsConf *RocksDBOptions) (*RocksDB, error) {
+	return nil, ErrNoRocksDB
+}
+
+func OpenRocksDBReadOnly(name string) (*RocksDB, error) {
+	return nil, ErrNoRocksDB
+}
+
+func OpenRocksDBSecondary(name string, secondaryPath string, optsConf *RocksDBOptions) (*RocksDB, error) {
+	return nil, ErrNoRocksDB
+}
+
+func (r *RocksDB) CatchUp() error {
+	return ErrNoRocksDB
+}
+
+func (r *RocksDB) Checkpoint(dir string) error {
+	return ErrNoRocksDB
+}
+
+func (r *RocksDB) GetRangeLimit(from, to []byte, limit int) ([]KVEntry, error) {
+	return nil, ErrNoRocksDB
+}
+
+func (r *RocksDB) GetRangeLimitCF(cf string, from, to []byte, limit int) ([]KVEntry, error) {
+	return nil, ErrNoRocksDB
+}
+
+func (r *RocksDB) NewBatchCF(cf string) *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) PutCF(cf string, key, value []byte) {}
+
+func (b *Batch) DeleteCF(cf string, key []byte) {}
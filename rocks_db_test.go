@@ -1,9 +1,14 @@
+//go:build !purego
+
 package main
 
 import (
+	"encoding/json"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/require"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"testing"
 )
@@ -108,3 +113,60 @@ func TestGetTicks(t *testing.T) {
 		require.True(t, tick.Equal(tickTests[i].expectTickState), "tick state should match")
 	}
 }
+
+func TestSecondary_CatchUp(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "testdb")
+	primary, err := NewRocksDB(dbPath, &RocksDBOptions{})
+	require.NoError(t, err)
+	defer primary.Close()
+	require.NoError(t, NewDB(primary).SetFinishHeight(100))
+
+	secondary, err := OpenRocksDBSecondary(dbPath, filepath.Join(dir, "secondary"), &RocksDBOptions{})
+	require.NoError(t, err)
+	defer secondary.Close()
+
+	addr := common.HexToAddress("0x5ec0000000000000000000000000000000000005")
+	require.NoError(t, NewDB(primary).SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(101),
+			TickSpacing: big.NewInt(60),
+			Tick:        big.NewInt(0),
+		},
+		TickStates: []*TickState{
+			{Tick: -60, LiquidityNet: big.NewInt(1000)},
+			{Tick: 60, LiquidityNet: big.NewInt(-1000)},
+		},
+	}))
+	require.NoError(t, NewDB(primary).SetFinishHeight(101))
+	require.NoError(t, secondary.CatchUp())
+
+	height, err := NewDB(secondary).GetFinishHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), height)
+
+	view, release := secondary.Snapshot()
+	height, err = NewDB(view).GetFinishHeight()
+	release()
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), height)
+
+	// scans of a secondary run without a snapshot
+	poolState, err := NewDB(secondary).GetPoolState(addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), poolState.Global.Height.Uint64())
+	require.Equal(t, map[int32]int64{-60: 1000, 60: -1000}, tickStatesToMap(poolState.TickStates))
+
+	api := &apiServer{db: NewDB(secondary)}
+	for _, sortBy := range []string{PoolSortAddress, PoolSortActivity} {
+		rec := httptest.NewRecorder()
+		api.HandlerPools(rec, httptest.NewRequest("GET", "/pools?sort="+sortBy, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		page := &PoolPage{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), page))
+		require.Len(t, page.Pools, 1)
+		require.Equal(t, addr, page.Pools[0].Address)
+		require.Equal(t, int64(2), page.Pools[0].TickCount)
+	}
+}
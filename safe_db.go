@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"math/big"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -73,6 +75,35 @@ func (s *SafeDB) GetTickStates(addr common.Address) ([]*TickState, error) {
 	return s.db.GetTickStates(addr)
 }
 
+// ScanTickStates holds the read lock of addr during the scan, fn must not write the pool
+func (s *SafeDB) ScanTickStates(addr common.Address, fromTick, toTick int32, fn func(tickState *TickState) error) error {
+	lock := s.getOrCreateLock(addr)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.ScanTickStates(addr, fromTick, toTick, fn)
+}
+
+func (s *SafeDB) GetTickStatesInRange(addr common.Address, fromTick, toTick int32) (*TickRange, error) {
+	lock := s.getOrCreateLock(addr)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.GetTickStatesInRange(addr, fromTick, toTick)
+}
+
+func (s *SafeDB) SetActiveLiquidity(addr common.Address, liquidity *big.Int) error {
+	lock := s.getOrCreateLock(addr)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.db.SetActiveLiquidity(addr, liquidity)
+}
+
+func (s *SafeDB) GetActiveLiquidity(addr common.Address) (*big.Int, error) {
+	lock := s.getOrCreateLock(addr)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.GetActiveLiquidity(addr)
+}
+
 func (s *SafeDB) SetCurrentTick(addr common.Address, tick int32) error {
 	lock := s.getOrCreateLock(addr)
 	lock.Lock()
@@ -122,6 +153,13 @@ func (s *SafeDB) GetPoolState(addr common.Address) (*PoolState, error) {
 	return s.db.GetPoolState(addr)
 }
 
+func (s *SafeDB) GetPoolStateInRange(addr common.Address, tickOffset int32) (*PoolState, error) {
+	lock := s.getOrCreateLock(addr)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.GetPoolStateInRange(addr, tickOffset)
+}
+
 func (s *SafeDB) SetPoolState(addr common.Address, poolState *PoolState) error {
 	lock := s.getOrCreateLock(addr)
 	lock.Lock()
@@ -136,6 +174,80 @@ func (s *SafeDB) DeletePoolState(addr common.Address) error {
 	return s.db.DeletePoolState(addr)
 }
 
+func (s *SafeDB) PrunePool(addr common.Address, height uint64) error {
+	lock := s.getOrCreateLock(addr)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.db.PrunePool(addr, height)
+}
+
+// WriteBlock locks the pools of the block in address order, so concurrent callers can't deadlock
+func (s *SafeDB) WriteBlock(w *BlockWrite) error {
+	for _, addr := range w.Touched() {
+		lock := s.getOrCreateLock(addr)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	return s.db.WriteBlock(w)
+}
+
+func (s *SafeDB) SetTickHistory(addr common.Address, height uint64, tickState *TickState) error {
+	lock := s.getOrCreateLock(addr)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.db.SetTickHistory(addr, height, tickState)
+}
+
+func (s *SafeDB) SetCurrentTickHistory(addr common.Address, height uint64, tick int32) error {
+	lock := s.getOrCreateLock(addr)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.db.SetCurrentTickHistory(addr, height, tick)
+}
+
+func (s *SafeDB) GetPoolStateAt(addr common.Address, height uint64) (*PoolState, error) {
+	lock := s.getOrCreateLock(addr)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.GetPoolStateAt(addr, height)
+}
+
+// PruneHistory only removes versions below before, which the reactor never writes again
+func (s *SafeDB) PruneHistory(ctx context.Context, before uint64) error {
+	return s.db.PruneHistory(ctx, before)
+}
+
+func (s *SafeDB) SetBlockTime(height uint64, timestamp uint64) error {
+	return s.db.SetBlockTime(height, timestamp)
+}
+
+func (s *SafeDB) GetBlockTime(height uint64) (uint64, error) {
+	return s.db.GetBlockTime(height)
+}
+
+func (s *SafeDB) GetHeightAt(timestamp uint64) (uint64, error) {
+	return s.db.GetHeightAt(timestamp)
+}
+
+func (s *SafeDB) AddEvents(addr common.Address, records []*EventRecord) error {
+	lock := s.getOrCreateLock(addr)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.db.AddEvents(addr, records)
+}
+
+func (s *SafeDB) GetEvents(query *EventQuery) (*EventPage, error) {
+	lock := s.getOrCreateLock(query.Address)
+	lock.RLock()
+	defer lock.RUnlock()
+	return s.db.GetEvents(query)
+}
+
+// ListPools reads the index on a snapshot, it needs no pool lock
+func (s *SafeDB) ListPools(query *PoolQuery) (*PoolPage, error) {
+	return s.db.ListPools(query)
+}
+
 func (s *SafeDB) CleanupLocks() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serveOnly runs only the api server on a secondary instance of the db. The
+// secondary catches up with the primary every CatchUpIntervalMs, pools the
+// primary has not ingested are not bootstrapped and nothing is written.
+func serveOnly(ctx context.Context) {
+	if backend := G.RocksDB.Backend; backend != "" && backend != BackendRocksDB {
+		Log.Fatal("serve-only needs the rocksdb backend")
+	}
+
+	secondaryPath := G.RocksDB.SecondaryPath
+	if secondaryPath == "" {
+		secondaryPath = filepath.Join(os.TempDir(), fmt.Sprintf("uniswapv3-tick-state-secondary-%d", os.Getpid()))
+		defer os.RemoveAll(secondaryPath)
+	}
+
+	rocksDB, err := OpenRocksDBSecondary(G.RocksDB.DBPath, secondaryPath, &RocksDBOptions{
+		EnableLog:      G.RocksDB.EnableLog,
+		BlockCacheSize: G.RocksDB.BlockCacheSize,
+	})
+	if err != nil {
+		Log.Fatal("failed to open secondary db", zap.Error(err))
+	}
+	defer rocksDB.Close()
+
+	// the primary migrates, a secondary only reads its own schema version
+	version, _, err := GetSchemaVersion(rocksDB)
+	if err != nil {
+		Log.Fatal("failed to get db schema version", zap.Error(err))
+	}
+	if version != SchemaVersion {
+		Log.Fatal("db schema version differs, start the primary to migrate first", zap.Uint32("db", version), zap.Uint32("supported", SchemaVersion))
+	}
+
+	db := NewDB(rocksDB)
+	psg := NewPoolStateGetter(newCache(), db, nil, nil)
+	as := NewAPIServer(G.API.Addr, "", psg, db, nil)
+	as.Start()
+
+	finishedHeight, err := db.GetFinishHeight()
+	if err != nil {
+		Log.Fatal("failed to get finished height", zap.Error(err))
+	}
+	Log.Info("serve-only started", zap.String("secondary", secondaryPath), zap.Uint64("finishedHeight", finishedHeight))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	catchUpInterval := time.Duration(G.RocksDB.CatchUpIntervalMs) * time.Millisecond
+	if catchUpInterval <= 0 {
+		catchUpInterval = time.Second
+	}
+
+	ticker := time.NewTicker(catchUpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigChan:
+			Log.Info("receive signal", zap.String("signal", sig.String()))
+			return
+		case <-ticker.C:
+			if err := rocksDB.CatchUp(); err != nil {
+				Log.Warn("failed to catch up with primary", zap.Error(err))
+			}
+		}
+	}
+}
@@ -18,6 +18,8 @@ type TaskDispatcher interface {
 
 type taskDispatcher struct {
 	ethClient    *ethclient.Client
+	retryPolicy  *RetryPolicy
+	rateLimiter  *RateLimiter
 	taskReceiver Output[uint64]
 	headerHeight MutexValue[uint64]
 	ethHeaders   chan *ethtypes.Header
@@ -32,6 +34,10 @@ func (d *taskDispatcher) Stop() {
 	d.stopped.Set(true)
 }
 
+func (d *taskDispatcher) blockNumber(ctx context.Context) (uint64, error) {
+	return RateLimited(ctx, d.rateLimiter, CallTypeBlockNumber, PriorityHigh, d.ethClient.BlockNumber)
+}
+
 func (d *taskDispatcher) subEthHeader(ctx context.Context) (ethereum.Subscription, <-chan error, error) {
 	sub, err := d.ethClient.SubscribeNewHead(ctx, d.ethHeaders)
 	if err != nil {
@@ -41,7 +47,7 @@ func (d *taskDispatcher) subEthHeader(ctx context.Context) (ethereum.Subscriptio
 }
 
 func (d *taskDispatcher) startSubEthHeader(ctx context.Context) {
-	height, err := d.ethClient.BlockNumber(ctx)
+	height, err := DoWithRetry(ctx, d.retryPolicy, 0, d.blockNumber)
 	if err != nil {
 		panic(err)
 	}
@@ -125,8 +131,10 @@ func NewTaskDispatcher(url string) TaskDispatcher {
 	}
 
 	return &taskDispatcher{
-		ethClient:  ethClient,
-		ethHeaders: make(chan *ethtypes.Header, 100),
+		ethClient:   ethClient,
+		retryPolicy: GetRetryPolicy(url),
+		rateLimiter: GetRateLimiter(url),
+		ethHeaders:  make(chan *ethtypes.Header, 100),
 	}
 }
 
@@ -139,7 +147,7 @@ func (d *taskDispatcher) GetFromHeight(ctx context.Context, fromHeight, finished
 		return finishedHeight + 1
 	}
 
-	height, err := d.ethClient.BlockNumber(ctx)
+	height, err := DoWithRetry(ctx, d.retryPolicy, 0, d.blockNumber)
 	if err != nil {
 		Log.Fatal("ethClient BlockNumber err", zap.Error(err))
 	}
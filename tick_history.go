@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+
+	"uniswapv3-tick-state/dbkey"
+)
+
+// Every change of a tick is also stored as a version keyed by the block height,
+// so the pool state can be rebuilt as of any height above the history floor:
+//
+//	6: | addr | ordered tick | height -> liquidityNet after the block
+//	7: | addr | height -> current tick after the block, empty from a deletion on
+//	8: -> history floor, versions below it are pruned
+//
+// A quarantined or pruned pool keeps its history, the deletion is a version of
+// its own: zero for the live ticks and the empty current tick, the pool reads as
+// unknown until it is bootstrapped again.
+
+var (
+	ErrHeightPruned      = errors.New("height is pruned from history")
+	ErrHeightNotIngested = errors.New("height is not ingested yet")
+)
+
+// unknownCurrentTick is the current tick version of a deleted pool
+var unknownCurrentTick = []byte{}
+
+const (
+	TickHistoryKeyLen        = dbkey.TickHistoryKeyLen
+	CurrentTickHistoryKeyLen = dbkey.CurrentTickHistoryKeyLen
+)
+
+func makeTickHistoryKey(addr common.Address, tick int32, height uint64) [TickHistoryKeyLen]byte {
+	var key [TickHistoryKeyLen]byte
+	copy(key[:2], KeyPrefixTickHistory)
+	copy(key[2:22], addr[:])
+	copy(key[22:26], int32ToOrderedBytes(tick))
+	copy(key[26:34], uint64ToBytes(height))
+	return key
+}
+
+func parseTickHistoryKey(key []byte) (addr common.Address, tick int32, height uint64) {
+	return common.BytesToAddress(key[2:22]), orderedBytesToInt32(key[22:26]), bytesToUint64(key[26:34])
+}
+
+func tickHistoryRange(addr common.Address) (from, to [TickHistoryKeyLen]byte) {
+	return makeTickHistoryKey(addr, MinInt24, 0), makeTickHistoryKey(addr, MaxInt24, math.MaxUint64)
+}
+
+func makeCurrentTickHistoryKey(addr common.Address, height uint64) [CurrentTickHistoryKeyLen]byte {
+	var key [CurrentTickHistoryKeyLen]byte
+	copy(key[:2], KeyPrefixCurrentTickHistory)
+	copy(key[2:22], addr[:])
+	copy(key[22:30], uint64ToBytes(height))
+	return key
+}
+
+func currentTickHistoryRange(addr common.Address) (from, to [CurrentTickHistoryKeyLen]byte) {
+	return makeCurrentTickHistoryKey(addr, 0), makeCurrentTickHistoryKey(addr, math.MaxUint64)
+}
+
+func (r *rocksDBWrap) SetTickHistory(addr common.Address, height uint64, tickState *TickState) error {
+	key := makeTickHistoryKey(addr, tickState.Tick, height)
+	value, err := tickState.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return r.db.Set(key[:], value)
+}
+
+func (r *rocksDBWrap) SetCurrentTickHistory(addr common.Address, height uint64, tick int32) error {
+	key := makeCurrentTickHistoryKey(addr, height)
+	return r.db.Set(key[:], int32ToBytes(tick))
+}
+
+func (r *rocksDBWrap) GetHistoryFloor() (uint64, error) {
+	bytes, err := r.db.Get(HistoryFloorKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if bytes == nil {
+		return 0, nil
+	}
+
+	return bytesToUint64(bytes), nil
+}
+
+// GetPoolStateAt rebuilds the pool state as of the end of block height, nil if the pool was unknown then
+func (r *rocksDBWrap) GetPoolStateAt(addr common.Address, height uint64) (*PoolState, error) {
+	v, release := r.view()
+	defer release()
+	return v.getPoolStateAt(addr, height)
+}
+
+func (r *rocksDBWrap) getPoolStateAt(addr common.Address, height uint64) (*PoolState, error) {
+	finishHeight, err := r.GetFinishHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	if height > finishHeight {
+		return nil, ErrHeightNotIngested
+	}
+
+	floor, err := r.GetHistoryFloor()
+	if err != nil {
+		return nil, err
+	}
+
+	if height < floor {
+		return nil, ErrHeightPruned
+	}
+
+	tickSpacing, err := r.GetTickSpacing(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tickSpacing == 0 {
+		return nil, nil
+	}
+
+	from, _ := currentTickHistoryRange(addr)
+	to := makeCurrentTickHistoryKey(addr, height)
+	entry, err := r.db.GetPrev(from[:], to[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil || len(entry.V()) == 0 {
+		return nil, nil
+	}
+	tick := bytesToInt32(entry.V())
+
+	// versions are sorted by tick and then by height, keep the last one not above height
+	var tickStates []*TickState
+	var last *TickState
+	tickHistoryFrom, tickHistoryTo := tickHistoryRange(addr)
+	err = r.db.Scan(tickHistoryFrom[:], tickHistoryTo[:], func(key, value []byte) error {
+		_, t, h := parseTickHistoryKey(key)
+		if h > height {
+			return nil
+		}
+
+		if last == nil || last.Tick != t {
+			last = NewTickState(t)
+			tickStates = append(tickStates, last)
+		}
+		return last.UnmarshalBinary(value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nonZero := tickStates[:0]
+	for _, ts := range tickStates {
+		if ts.LiquidityNet.Sign() != 0 {
+			nonZero = append(nonZero, ts)
+		}
+	}
+
+	return &PoolState{
+		Global: &PoolGlobalState{
+			Height:      new(big.Int).SetUint64(height),
+			TickSpacing: big.NewInt(int64(tickSpacing)),
+			Tick:        big.NewInt(int64(tick)),
+		},
+		TickStates: nonZero,
+	}, nil
+}
+
+// PruneHistory drops the versions that are not needed to answer queries at heights >= before.
+// The floor is raised first, so no query reads a half pruned height. A run stopped by ctx
+// leaves dead versions below the floor, the next run removes them.
+func (r *rocksDBWrap) PruneHistory(ctx context.Context, before uint64) error {
+	floor, err := r.GetHistoryFloor()
+	if err != nil {
+		return err
+	}
+
+	if before <= floor {
+		return nil
+	}
+
+	if err = r.db.Set(HistoryFloorKey, uint64ToBytes(before)); err != nil {
+		return err
+	}
+
+	// the index also holds the deleted pools, their history stays until it ages out
+	pools := 0
+	from, to := makePoolInfoKey(common.Address{}), makePoolInfoKey(maxAddr)
+	err = r.db.Scan(from[:], to[:], func(key, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pools++
+		return r.prunePoolHistory(ctx, common.BytesToAddress(key[2:22]), before)
+	})
+	if err != nil {
+		return err
+	}
+
+	Log.Info("history pruned", zap.Uint64("before", before), zap.Int("pools", pools))
+	return nil
+}
+
+// pruneBatchSize bounds the deletes held in memory while pruning a pool
+const pruneBatchSize = 10000
+
+func (r *rocksDBWrap) prunePoolHistory(ctx context.Context, addr common.Address, before uint64) error {
+	batch := r.db.NewBatch()
+	defer func() { batch.Destroy() }()
+
+	pending := 0
+	del := func(key []byte) error {
+		batch.Delete(key)
+		pending++
+		if pending < pruneBatchSize {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.db.WriteBatch(batch); err != nil {
+			return err
+		}
+		batch.Destroy()
+		batch = r.db.NewBatch()
+		pending = 0
+		return nil
+	}
+
+	// within a tick, every version below before except the last one is dead,
+	// and so is the last one when it is zero. prev is decided when its successor is seen.
+	var prevKey []byte
+	var prevTick int32
+	var prevZero bool
+	settle := func(nextTick int32, nextHeight uint64, hasNext bool) error {
+		if prevKey == nil {
+			return nil
+		}
+
+		if (hasNext && nextTick == prevTick && nextHeight < before) || prevZero {
+			return del(prevKey)
+		}
+		return nil
+	}
+
+	from, to := tickHistoryRange(addr)
+	err := r.db.Scan(from[:], to[:], func(key, value []byte) error {
+		_, tick, height := parseTickHistoryKey(key)
+		if err := settle(tick, height, true); err != nil {
+			return err
+		}
+		prevKey = nil
+
+		if height >= before {
+			return nil
+		}
+
+		ts := NewTickState(tick)
+		if err := ts.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		prevKey, prevTick, prevZero = append([]byte{}, key...), tick, ts.LiquidityNet.Sign() == 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = settle(0, 0, false); err != nil {
+		return err
+	}
+
+	// stored events share the history retention, /events only reaches back as far as the history
+	eventFrom := makeEventKey(addr, 0, 0)
+	eventTo := makeEventKey(addr, before, 0)
+	batch.DeleteRange(eventFrom[:], eventTo[:])
+
+	// keep the last current tick below before
+	prevKey = nil
+	currentFrom, _ := currentTickHistoryRange(addr)
+	currentTo := makeCurrentTickHistoryKey(addr, before-1)
+	err = r.db.Scan(currentFrom[:], currentTo[:], func(key, value []byte) error {
+		if prevKey != nil {
+			if err := del(prevKey); err != nil {
+				return err
+			}
+		}
+		prevKey = append([]byte{}, key...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.db.WriteBatch(batch)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func tickStatesToMap(tickStates []*TickState) map[int32]int64 {
+	m := make(map[int32]int64)
+	for _, ts := range tickStates {
+		m[ts.Tick] = ts.LiquidityNet.Int64()
+	}
+	return m
+}
+
+func Test_GetPoolStateAt_Prune(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	addr := common.HexToAddress("0x6000000000000000000000000000000000000006")
+
+	err := repo.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(100),
+			TickSpacing: big.NewInt(10),
+			Tick:        big.NewInt(5),
+		},
+		TickStates: []*TickState{
+			{Tick: -10, LiquidityNet: big.NewInt(100)},
+			{Tick: 10, LiquidityNet: big.NewInt(-100)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetPoolState failed: %v", err)
+	}
+
+	// block 101: mint [-20, 10], block 103: burn [-10, 10] and swap
+	w := NewBlockWrite(repo, 101, 0)
+	for _, e := range []*Event{{Type: EventTypeMint, Address: addr, TickLower: big.NewInt(-20), TickUpper: big.NewInt(10), Amount: big.NewInt(50)}} {
+		if err := ApplyEvent(w, e); err != nil {
+			t.Fatalf("ApplyEvent failed: %v", err)
+		}
+	}
+	if err := repo.WriteBlock(w); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	w = NewBlockWrite(repo, 103, 0)
+	for _, e := range []*Event{
+		{Type: EventTypeBurn, Address: addr, TickLower: big.NewInt(-10), TickUpper: big.NewInt(10), Amount: big.NewInt(100)},
+		{Type: EventTypeSwap, Address: addr, Tick: big.NewInt(-15)},
+	} {
+		if err := ApplyEvent(w, e); err != nil {
+			t.Fatalf("ApplyEvent failed: %v", err)
+		}
+	}
+	if err := repo.WriteBlock(w); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	if err := repo.SetFinishHeight(105); err != nil {
+		t.Fatalf("SetFinishHeight failed: %v", err)
+	}
+
+	tests := []struct {
+		height uint64
+		tick   int64
+		ticks  map[int32]int64
+	}{
+		{100, 5, map[int32]int64{-10: 100, 10: -100}},
+		{102, 5, map[int32]int64{-20: 50, -10: 100, 10: -150}},
+		{105, -15, map[int32]int64{-20: 50, 10: -50}},
+	}
+	for _, test := range tests {
+		poolState, err := repo.GetPoolStateAt(addr, test.height)
+		if err != nil {
+			t.Fatalf("GetPoolStateAt(%d) failed: %v", test.height, err)
+		}
+		if poolState.Global.Height.Uint64() != test.height || poolState.Global.Tick.Int64() != test.tick || poolState.Global.TickSpacing.Int64() != 10 {
+			t.Fatalf("GetPoolStateAt(%d): wrong global %+v", test.height, poolState.Global)
+		}
+		ticks := tickStatesToMap(poolState.TickStates)
+		if len(ticks) != len(test.ticks) {
+			t.Fatalf("GetPoolStateAt(%d): want %v, got %v", test.height, test.ticks, ticks)
+		}
+		for tick, liquidityNet := range test.ticks {
+			if ticks[tick] != liquidityNet {
+				t.Fatalf("GetPoolStateAt(%d): want %v, got %v", test.height, test.ticks, ticks)
+			}
+		}
+	}
+
+	poolState, err := repo.GetPoolStateAt(addr, 99)
+	if err != nil || poolState != nil {
+		t.Fatalf("GetPoolStateAt(99): want nil, got %v, %v", poolState, err)
+	}
+
+	if _, err = repo.GetPoolStateAt(addr, 106); !errors.Is(err, ErrHeightNotIngested) {
+		t.Fatalf("GetPoolStateAt(106): want ErrHeightNotIngested, got %v", err)
+	}
+
+	if err = repo.PruneHistory(context.Background(), 103); err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+
+	if _, err = repo.GetPoolStateAt(addr, 102); !errors.Is(err, ErrHeightPruned) {
+		t.Fatalf("GetPoolStateAt(102): want ErrHeightPruned, got %v", err)
+	}
+
+	for _, height := range []uint64{103, 105} {
+		poolState, err = repo.GetPoolStateAt(addr, height)
+		if err != nil {
+			t.Fatalf("GetPoolStateAt(%d) after prune failed: %v", height, err)
+		}
+		ticks := tickStatesToMap(poolState.TickStates)
+		if poolState.Global.Tick.Int64() != -15 || len(ticks) != 2 || ticks[-20] != 50 || ticks[10] != -50 {
+			t.Fatalf("GetPoolStateAt(%d) after prune: got tick %v, ticks %v", height, poolState.Global.Tick, ticks)
+		}
+	}
+}
+
+func Test_PruneHistory_Stopped(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	addr := common.HexToAddress("0x6100000000000000000000000000000000000061")
+
+	err := repo.SetPoolState(addr, &PoolState{
+		Global: &PoolGlobalState{
+			Height:      big.NewInt(100),
+			TickSpacing: big.NewInt(10),
+			Tick:        big.NewInt(5),
+		},
+		TickStates: []*TickState{
+			{Tick: -10, LiquidityNet: big.NewInt(100)},
+			{Tick: 10, LiquidityNet: big.NewInt(-100)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetPoolState failed: %v", err)
+	}
+	w := NewBlockWrite(repo, 101, 0)
+	if err = ApplyEvent(w, &Event{Type: EventTypeSwap, Address: addr, Tick: big.NewInt(-5)}); err != nil {
+		t.Fatalf("ApplyEvent failed: %v", err)
+	}
+	if err = repo.WriteBlock(w); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	if err = repo.SetFinishHeight(105); err != nil {
+		t.Fatalf("SetFinishHeight failed: %v", err)
+	}
+
+	// the floor is raised before anything is deleted, also when the run is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = repo.PruneHistory(ctx, 103); !errors.Is(err, context.Canceled) {
+		t.Fatalf("PruneHistory: want context.Canceled, got %v", err)
+	}
+	if _, err = repo.GetPoolStateAt(addr, 102); !errors.Is(err, ErrHeightPruned) {
+		t.Fatalf("GetPoolStateAt(102): want ErrHeightPruned, got %v", err)
+	}
+
+	// a later run finishes the work
+	if err = repo.PruneHistory(context.Background(), 104); err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+	for _, height := range []uint64{104, 105} {
+		poolState, err := repo.GetPoolStateAt(addr, height)
+		if err != nil {
+			t.Fatalf("GetPoolStateAt(%d) failed: %v", height, err)
+		}
+		ticks := tickStatesToMap(poolState.TickStates)
+		if poolState.Global.Tick.Int64() != -5 || len(ticks) != 2 || ticks[-10] != 100 || ticks[10] != -100 {
+			t.Fatalf("GetPoolStateAt(%d): got tick %v, ticks %v", height, poolState.Global.Tick, ticks)
+		}
+	}
+}
+
+func Test_GetPoolStateAt_Deleted(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	addr := common.HexToAddress("0x6200000000000000000000000000000000000062")
+
+	setPoolState := func(height int64, tick int64, tickStates ...*TickState) {
+		err := repo.SetPoolState(addr, &PoolState{
+			Global:     &PoolGlobalState{Height: big.NewInt(height), TickSpacing: big.NewInt(10), Tick: big.NewInt(tick)},
+			TickStates: tickStates,
+		})
+		if err != nil {
+			t.Fatalf("SetPoolState failed: %v", err)
+		}
+	}
+	setFinishHeight := func(height uint64) {
+		if err := repo.SetFinishHeight(height); err != nil {
+			t.Fatalf("SetFinishHeight failed: %v", err)
+		}
+	}
+	// wantTicks nil wants the pool unknown at height
+	check := func(height uint64, wantTicks map[int32]int64) {
+		poolState, err := repo.GetPoolStateAt(addr, height)
+		if err != nil {
+			t.Fatalf("GetPoolStateAt(%d) failed: %v", height, err)
+		}
+		if wantTicks == nil {
+			if poolState != nil {
+				t.Fatalf("GetPoolStateAt(%d): want nil, got %v", height, tickStatesToMap(poolState.TickStates))
+			}
+			return
+		}
+		if poolState == nil {
+			t.Fatalf("GetPoolStateAt(%d): want %v, got nil", height, wantTicks)
+		}
+		ticks := tickStatesToMap(poolState.TickStates)
+		if len(ticks) != len(wantTicks) {
+			t.Fatalf("GetPoolStateAt(%d): want %v, got %v", height, wantTicks, ticks)
+		}
+		for tick, liquidityNet := range wantTicks {
+			if ticks[tick] != liquidityNet {
+				t.Fatalf("GetPoolStateAt(%d): want %v, got %v", height, wantTicks, ticks)
+			}
+		}
+	}
+
+	setPoolState(100, 5, &TickState{Tick: -10, LiquidityNet: big.NewInt(100)}, &TickState{Tick: 10, LiquidityNet: big.NewInt(-100)})
+
+	// quarantined in block 102, the state of block 101 is still there
+	w := NewBlockWrite(repo, 102, 0)
+	w.Delete(addr)
+	if err := repo.WriteBlock(w); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	setFinishHeight(102)
+	if poolState, err := repo.GetPoolState(addr); err != nil || poolState != nil {
+		t.Fatalf("GetPoolState: want nil, got %v, %v", poolState, err)
+	}
+	check(101, map[int32]int64{-10: 100, 10: -100})
+	check(102, nil)
+
+	// bootstrapped again at 104 with other ticks, the old ones do not come back
+	setPoolState(104, 25, &TickState{Tick: 20, LiquidityNet: big.NewInt(7)}, &TickState{Tick: 30, LiquidityNet: big.NewInt(-7)})
+	setFinishHeight(106)
+	check(101, map[int32]int64{-10: 100, 10: -100})
+	check(103, nil)
+	check(105, map[int32]int64{20: 7, 30: -7})
+
+	// pruned after block 106, which still answers
+	if err := repo.PrunePool(addr, 106); err != nil {
+		t.Fatalf("PrunePool failed: %v", err)
+	}
+	setFinishHeight(107)
+	check(106, map[int32]int64{20: 7, 30: -7})
+	check(107, nil)
+
+	// the history of the deleted pool ages out with the retention
+	if err := repo.PruneHistory(context.Background(), 108); err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+	from, to := tickHistoryRange(addr)
+	versions := 0
+	err := repo.(*rocksDBWrap).db.Scan(from[:], to[:], func(key, value []byte) error {
+		versions++
+		return nil
+	})
+	if err != nil || versions != 0 {
+		t.Fatalf("tick history after PruneHistory: want none, got %d, %v", versions, err)
+	}
+}
@@ -1,68 +1,40 @@
 package main
 
 import (
-	"encoding/binary"
 	"github.com/ethereum/go-ethereum/common"
-)
 
-const (
-	TickStateKeyLen = 26
+	"uniswapv3-tick-state/dbkey"
 )
 
-type TickStateKey [TickStateKeyLen]byte
-
-func (k *TickStateKey) setBytes(b []byte) {
-	copy(k[:], b)
-}
-
-func (k TickStateKey) GetAddress() common.Address {
-	return common.BytesToAddress(k[2:22])
-}
+// The key layout is in dbkey/key.go, shared with cmd/dump_rocks_db
 
-func (k TickStateKey) GetTick() int32 {
-	return orderedBytesToInt32(k[22:26])
-}
+const (
+	TickStateKeyLen = dbkey.TickStateKeyLen
+)
 
-func (k TickStateKey) GetKey() []byte {
-	return k[:]
-}
+type TickStateKey = dbkey.TickStateKey
 
 func GetTickStateKey(addr common.Address, tick int32) TickStateKey {
-	var key TickStateKey
-	copy(key[:2], KeyPrefixTickState)
-	copy(key[2:22], addr[:])
-	copy(key[22:], int32ToOrderedBytes(tick))
-	return key
+	return dbkey.GetTickStateKey(addr, tick)
 }
 
 func BytesToTickStateKey(bytes []byte) TickStateKey {
-	if len(bytes) != TickStateKeyLen {
-		panic("unexpected bytes length") // TODO check
-	}
-
-	var key TickStateKey
-	key.setBytes(bytes)
-	return key
+	return dbkey.BytesToTickStateKey(bytes)
 }
 
 func int32ToOrderedBytes(n int32) []byte {
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(n)+0x80000000)
-	return buf
+	return dbkey.Int32ToOrderedBytes(n)
 }
 
 func orderedBytesToInt32(bytes []byte) int32 {
-	if len(bytes) != 4 {
-		return 0
-	}
-	return int32(binary.BigEndian.Uint32(bytes) - 0x80000000)
+	return dbkey.OrderedBytesToInt32(bytes)
 }
 
 const (
-	MinTick  = int32(-887272) // uniswap v3 core: ./contracts/libraries/TickMath.sol:9: int24 internal constant MIN_TICK = -887272;
-	MaxTick  = int32(887272)
-	MinInt24 = int32(-8388608)
-	MaxInt24 = int32(8388607)
+	MinTick  = dbkey.MinTick
+	MaxTick  = dbkey.MaxTick
+	MinInt24 = dbkey.MinInt24
+	MaxInt24 = dbkey.MaxInt24
 )
 
 var (
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// b: | addr -> active liquidity at the current tick, the sum of liquidityNet of
+// all ticks <= current tick (the liquidity() of the pool contract). It lets a
+// window of ticks be read without summing the pool from MinTick.
+
+// TickRange is a window of the tick map
+type TickRange struct {
+	BaseLiquidity *big.Int // sum of liquidityNet of the ticks below TickStates[0]
+	TickStates    []*TickState
+}
+
+func makeActiveLiquidityKey(addr common.Address) [22]byte {
+	var key [22]byte
+	copy(key[:2], KeyPrefixActiveLiquidity)
+	copy(key[2:22], addr[:])
+	return key
+}
+
+func sumLiquidityNetUpTo(tickStates []*TickState, tick int32) *big.Int {
+	sum := big.NewInt(0)
+	for _, ts := range tickStates {
+		if ts.Tick <= tick {
+			sum.Add(sum, ts.LiquidityNet)
+		}
+	}
+	return sum
+}
+
+func (r *rocksDBWrap) SetActiveLiquidity(addr common.Address, liquidity *big.Int) error {
+	value, err := int128ToBytes(liquidity)
+	if err != nil {
+		return err
+	}
+	key := makeActiveLiquidityKey(addr)
+	return r.db.Set(key[:], value)
+}
+
+// GetActiveLiquidity returns nil if the pool has no checkpoint yet
+func (r *rocksDBWrap) GetActiveLiquidity(addr common.Address) (*big.Int, error) {
+	key := makeActiveLiquidityKey(addr)
+	bytes, err := r.db.Get(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes == nil {
+		return nil, nil
+	}
+
+	liquidity := new(big.Int)
+	if err := bytesToInt128(bytes, liquidity); err != nil {
+		return nil, err
+	}
+	return liquidity, nil
+}
+
+func (r *rocksDBWrap) getTickStatesBetween(addr common.Address, fromTick, toTick int32) ([]*TickState, error) {
+	if fromTick > toTick {
+		return nil, nil
+	}
+
+	var tickStates []*TickState
+	err := r.ScanTickStates(addr, fromTick, toTick, func(tickState *TickState) error {
+		tickStates = append(tickStates, tickState)
+		return nil
+	})
+	return tickStates, err
+}
+
+// sumLiquidityNetBetween sums the ticks in [fromTick, toTick] without holding them
+func (r *rocksDBWrap) sumLiquidityNetBetween(addr common.Address, fromTick, toTick int32) (*big.Int, error) {
+	sum := big.NewInt(0)
+	if fromTick > toTick {
+		return sum, nil
+	}
+
+	err := r.ScanTickStates(addr, fromTick, toTick, func(tickState *TickState) error {
+		sum.Add(sum, tickState.LiquidityNet)
+		return nil
+	})
+	return sum, err
+}
+
+// GetTickStatesInRange reads the ticks in [fromTick, toTick] plus the nearest
+// tick on each side, so the ranges crossing the window edges are complete.
+// Only the ticks between the window and the current tick are read besides.
+func (r *rocksDBWrap) GetTickStatesInRange(addr common.Address, fromTick, toTick int32) (*TickRange, error) {
+	v, release := r.view()
+	defer release()
+	return v.getTickStatesInRange(addr, fromTick, toTick)
+}
+
+// GetPoolStateInRange is GetPoolState with only the ticks of CalculateTickRange
+// around the current tick, read from one snapshot. nil if the pool is unknown.
+func (r *rocksDBWrap) GetPoolStateInRange(addr common.Address, tickOffset int32) (*PoolState, error) {
+	v, release := r.view()
+	defer release()
+
+	height, err := v.stateHeight(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if height == 0 {
+		return nil, nil
+	}
+
+	tickSpacing, err := v.GetTickSpacing(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tick, err := v.GetCurrentTick(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	fromTick, toTick := CalculateTickRange(tick, tickOffset, tickSpacing)
+	tickRange, err := v.getTickStatesInRange(addr, fromTick, toTick)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PoolState{
+		Global: &PoolGlobalState{
+			Height:      new(big.Int).SetUint64(height),
+			TickSpacing: big.NewInt(int64(tickSpacing)),
+			Tick:        big.NewInt(int64(tick)),
+		},
+		TickStates:    tickRange.TickStates,
+		BaseLiquidity: tickRange.BaseLiquidity,
+	}, nil
+}
+
+func (r *rocksDBWrap) getTickStatesInRange(addr common.Address, fromTick, toTick int32) (*TickRange, error) {
+	lo, hi := fromTick, toTick
+	poolFrom, poolTo := GetTickStateKey(addr, MinInt24).GetKey(), GetTickStateKey(addr, MaxInt24).GetKey()
+
+	if fromTick > MinInt24 {
+		prev, err := r.db.GetPrev(poolFrom, GetTickStateKey(addr, fromTick-1).GetKey())
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			lo = BytesToTickStateKey(prev.K()).GetTick()
+		}
+	}
+
+	if toTick < MaxInt24 {
+		next, err := r.db.GetNext(GetTickStateKey(addr, toTick+1).GetKey(), poolTo)
+		if err != nil {
+			return nil, err
+		}
+		if next != nil {
+			hi = BytesToTickStateKey(next.K()).GetTick()
+		}
+	}
+
+	tickStates, err := r.getTickStatesBetween(addr, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	activeLiquidity, err := r.GetActiveLiquidity(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if activeLiquidity == nil {
+		// no checkpoint yet, sum from MinTick
+		base, err := r.sumLiquidityNetBetween(addr, MinInt24, lo-1)
+		if err != nil {
+			return nil, err
+		}
+		return &TickRange{BaseLiquidity: base, TickStates: tickStates}, nil
+	}
+
+	currentTick, err := r.GetCurrentTick(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// base = active - sum[lo, current] when lo <= current, active + sum(current, lo) otherwise
+	base := new(big.Int).Set(activeLiquidity)
+	if lo <= currentTick {
+		base.Sub(base, sumLiquidityNetUpTo(tickStates, currentTick))
+		if currentTick > hi {
+			between, err := r.sumLiquidityNetBetween(addr, hi+1, currentTick)
+			if err != nil {
+				return nil, err
+			}
+			base.Sub(base, between)
+		}
+	} else {
+		between, err := r.sumLiquidityNetBetween(addr, currentTick+1, lo-1)
+		if err != nil {
+			return nil, err
+		}
+		base.Add(base, between)
+	}
+
+	return &TickRange{BaseLiquidity: base, TickStates: tickStates}, nil
+}
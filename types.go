@@ -10,8 +10,9 @@ import (
 )
 
 type BlockReceipt struct {
-	Height   uint64
-	Receipts []*types.Receipt
+	Height    uint64
+	Timestamp uint64
+	Receipts  []*types.Receipt
 }
 
 func (b *BlockReceipt) Sequence() uint64 {
@@ -22,6 +23,7 @@ const (
 	EventTypeMint = iota + 1
 	EventTypeBurn
 	EventTypeSwap
+	EventTypeInitialize
 )
 
 type Event struct {
@@ -31,11 +33,20 @@ type Event struct {
 	TickUpper *big.Int
 	Amount    *big.Int
 	Tick      *big.Int
+	// only set for Swap and Initialize events
+	SqrtPriceX96 *big.Int
+	Liquidity    *big.Int
+	// only set for Mint and Burn events
+	Owner common.Address
+	// the log the event was parsed from, set for every event
+	TxHash   common.Hash
+	LogIndex uint
 }
 
 type BlockEvent struct {
-	Height uint64
-	Events []*Event
+	Height    uint64
+	Timestamp uint64
+	Events    []*Event
 }
 
 func (b *BlockEvent) Sequence() uint64 {
@@ -66,17 +77,18 @@ func (t *TickState) Equal(other *TickState) bool {
 }
 
 func (t *TickState) MarshalBinary() ([]byte, error) {
-	return t.LiquidityNet.GobEncode()
+	return int128ToBytes(t.LiquidityNet)
 }
 
 func (t *TickState) UnmarshalBinary(data []byte) error {
-	return t.LiquidityNet.GobDecode(data)
+	return bytesToInt128(data, t.LiquidityNet)
 }
 
 type PoolGlobalState struct {
 	Height      *big.Int `json:"height"`
 	TickSpacing *big.Int `json:"tickSpacing"`
 	Tick        *big.Int `json:"tick"`
+	Timestamp   uint64   `json:"timestamp,omitempty"` // block time of Height, if indexed
 }
 
 type Token struct {
@@ -89,6 +101,8 @@ type PoolState struct {
 	Token1     *Token
 	Global     *PoolGlobalState
 	TickStates []*TickState
+	// only set when TickStates is a window of the pool: sum of liquidityNet below the first tick
+	BaseLiquidity *big.Int `json:",omitempty"`
 }
 
 func (s *PoolState) String() string {
@@ -104,6 +118,7 @@ type CallContractReq struct {
 	BlockNumber *big.Int
 	Address     common.Address
 	Data        []byte
+	Overrides   map[common.Address][]byte // contract code injected through eth_call state override
 }
 
 func (r *CallContractReq) String() string {
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
+
+// Checks of VerifyDB, a violation names the one it failed
+const (
+	CheckKeyDecode     = "key_decode"     // tick keys decode with BytesToTickStateKey
+	CheckValueDecode   = "value_decode"   // tick values decode as int128
+	CheckLiquidityNet  = "liquidity_net"  // the LiquidityNet of a pool sums to zero
+	CheckTickRange     = "tick_range"     // ticks are within MinTick..MaxTick
+	CheckTickSpacing   = "tick_spacing"   // ticks are multiples of the stored tick spacing
+	CheckPoolMetadata  = "pool_metadata"  // pools with ticks have height, spacing and current tick
+	CheckPoolHeight    = "pool_height"    // no pool height exceeds the finish height
+	CheckSchemaVersion = "schema_version" // the db is of SchemaVersion
+)
+
+const verifyMaxViolations = 1000
+
+type VerifyViolation struct {
+	Check  string
+	Pool   common.Address // zero for keys that do not decode
+	Detail string
+}
+
+type VerifyReport struct {
+	FinishHeight uint64
+	Pools        int
+	Ticks        int
+	Violations   []*VerifyViolation
+	// Dropped counts the violations beyond verifyMaxViolations, which are not kept
+	Dropped int
+}
+
+func (r *VerifyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *VerifyReport) add(check string, pool common.Address, format string, args ...any) {
+	if len(r.Violations) >= verifyMaxViolations {
+		r.Dropped++
+		return
+	}
+	r.Violations = append(r.Violations, &VerifyViolation{Check: check, Pool: pool, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Print writes the report, one line per violation and a count per check
+func (r *VerifyReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "finish height: %d\npools: %d\nticks: %d\n", r.FinishHeight, r.Pools, r.Ticks)
+
+	counts := make(map[string]int)
+	for _, v := range r.Violations {
+		counts[v.Check]++
+		if v.Pool == (common.Address{}) {
+			fmt.Fprintf(w, "%s: %s\n", v.Check, v.Detail)
+		} else {
+			fmt.Fprintf(w, "%s: %s: %s\n", v.Check, v.Pool.Hex(), v.Detail)
+		}
+	}
+
+	if r.OK() {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	checks := make([]string, 0, len(counts))
+	for check := range counts {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		fmt.Fprintf(w, "%s violations: %d\n", check, counts[check])
+	}
+	if r.Dropped > 0 {
+		fmt.Fprintf(w, "more violations not listed: %d\n", r.Dropped)
+	}
+}
+
+// prefixRange is the [from, to] range of the keys of a prefix
+func prefixRange(prefix []byte) ([]byte, []byte) {
+	return prefix, append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xff}, TickStateKeyLen)...)
+}
+
+// decodeTickStateKey is BytesToTickStateKey returning its panic as an error
+func decodeTickStateKey(key []byte) (tickKey TickStateKey, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	return BytesToTickStateKey(key), nil
+}
+
+// verifyPool is the state of the pool VerifyDB is walking the ticks of
+type verifyPool struct {
+	addr  common.Address
+	sum   *big.Int
+	ticks []int32
+}
+
+// VerifyDB walks the db on one snapshot and checks the invariants of the stored pools
+func VerifyDB(store KVStore) (*VerifyReport, error) {
+	view, release := store.Snapshot()
+	defer release()
+	db := &rocksDBWrap{db: view}
+
+	report := &VerifyReport{}
+
+	version, exists, err := GetSchemaVersion(view)
+	if err != nil {
+		return nil, err
+	}
+	if exists && version != SchemaVersion {
+		report.add(CheckSchemaVersion, common.Address{}, "db version %d, supported %d", version, SchemaVersion)
+		return report, nil
+	}
+
+	if report.FinishHeight, err = db.GetFinishHeight(); err != nil {
+		return nil, err
+	}
+
+	var pool *verifyPool
+	finishPool := func() error {
+		if pool == nil {
+			return nil
+		}
+		report.Pools++
+		return verifyPoolTicks(db, report, pool)
+	}
+
+	from, to := prefixRange(KeyPrefixTickState)
+	err = view.Scan(from, to, func(key, value []byte) error {
+		tickKey, err := decodeTickStateKey(key)
+		if err != nil {
+			report.add(CheckKeyDecode, common.Address{}, "key %x: %v", key, err)
+			return nil
+		}
+
+		addr, tick := tickKey.GetAddress(), tickKey.GetTick()
+		if pool == nil || pool.addr != addr {
+			if err = finishPool(); err != nil {
+				return err
+			}
+			pool = &verifyPool{addr: addr, sum: new(big.Int)}
+		}
+
+		tickState := NewTickState(tick)
+		if err = tickState.UnmarshalBinary(value); err != nil {
+			report.add(CheckValueDecode, addr, "tick %d: %v", tick, err)
+			return nil
+		}
+
+		report.Ticks++
+		pool.sum.Add(pool.sum, tickState.LiquidityNet)
+		pool.ticks = append(pool.ticks, tick)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = finishPool(); err != nil {
+		return nil, err
+	}
+
+	if err = verifyPoolHeights(view, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func verifyPoolTicks(db *rocksDBWrap, report *VerifyReport, pool *verifyPool) error {
+	if pool.sum.Sign() != 0 {
+		report.add(CheckLiquidityNet, pool.addr, "liquidityNet sums to %s", pool.sum)
+	}
+
+	for _, tick := range pool.ticks {
+		if tick < MinTick || tick > MaxTick {
+			report.add(CheckTickRange, pool.addr, "tick %d", tick)
+		}
+	}
+
+	var missing []string
+	for _, key := range []struct {
+		name string
+		key  [22]byte
+	}{
+		{"height", makePoolHeightKey(pool.addr)},
+		{"tick spacing", makeTickSpacingKey(pool.addr)},
+		{"current tick", makeCurrentTickKey(pool.addr)},
+	} {
+		value, err := db.db.Get(key.key[:])
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			missing = append(missing, key.name)
+		}
+	}
+	if len(missing) > 0 {
+		report.add(CheckPoolMetadata, pool.addr, "missing %v", missing)
+	}
+
+	tickSpacing, err := db.GetTickSpacing(pool.addr)
+	if err != nil {
+		return err
+	}
+	if tickSpacing <= 0 {
+		if len(missing) == 0 {
+			report.add(CheckTickSpacing, pool.addr, "tick spacing %d", tickSpacing)
+		}
+		return nil
+	}
+	for _, tick := range pool.ticks {
+		if tick%tickSpacing != 0 {
+			report.add(CheckTickSpacing, pool.addr, "tick %d, spacing %d", tick, tickSpacing)
+		}
+	}
+
+	return nil
+}
+
+// verifyPoolHeights checks the pool heights against the finish height. A db
+// with no finished block yet only holds bootstrapped pools and is skipped.
+func verifyPoolHeights(view KVStore, report *VerifyReport) error {
+	if report.FinishHeight == 0 {
+		return nil
+	}
+
+	from, to := prefixRange(KeyPrefixPoolHeight)
+	return view.Scan(from, to, func(key, value []byte) error {
+		if len(key) != 22 || len(value) != 8 {
+			report.add(CheckPoolHeight, common.Address{}, "key %x: value %x", key, value)
+			return nil
+		}
+
+		height := bytesToUint64(value)
+		if height > report.FinishHeight {
+			report.add(CheckPoolHeight, common.BytesToAddress(key[2:]), "height %d, finish height %d", height, report.FinishHeight)
+		}
+		return nil
+	})
+}
+
+// verify checks the configured db, prints the report and returns the exit code
+func verify() int {
+	store, err := openVerifyStore()
+	if err != nil {
+		Log.Error("failed to open db", zap.Error(err))
+		return 2
+	}
+	defer store.Close()
+
+	report, err := VerifyDB(store)
+	if err != nil {
+		Log.Error("failed to verify db", zap.Error(err))
+		return 2
+	}
+
+	report.Print(os.Stdout)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// openVerifyStore opens rocksdb read-only so verify can run beside the live process
+func openVerifyStore() (KVStore, error) {
+	backend := G.RocksDB.Backend
+	if backend == "" {
+		backend = DefaultBackend
+	}
+
+	if backend == BackendRocksDB {
+		db, err := OpenRocksDBReadOnly(G.RocksDB.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+
+	return OpenKVStore(backend, G.RocksDB.DBPath, &RocksDBOptions{
+		BlockCacheSize:  G.RocksDB.BlockCacheSize,
+		WriteBufferSize: G.RocksDB.WriteBufferSize,
+	})
+}
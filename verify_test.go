@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestVerifyDB(t *testing.T) {
+	store := newTestKVStore(t, BackendMemory)
+	defer store.Close()
+	if err := MigrateSchema(store, Migrations, SchemaVersion); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
+	}
+	db := NewDB(store)
+
+	good := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	err := db.SetPoolState(good, &PoolState{
+		Global: &PoolGlobalState{Height: big.NewInt(100), TickSpacing: big.NewInt(60), Tick: big.NewInt(30)},
+		TickStates: []*TickState{
+			{Tick: -120, LiquidityNet: big.NewInt(500)},
+			{Tick: 0, LiquidityNet: big.NewInt(200)},
+			{Tick: 180, LiquidityNet: big.NewInt(-700)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetPoolState failed: %v", err)
+	}
+	if err = db.SetFinishHeight(100); err != nil {
+		t.Fatalf("SetFinishHeight failed: %v", err)
+	}
+
+	report, err := VerifyDB(store)
+	if err != nil {
+		t.Fatalf("VerifyDB failed: %v", err)
+	}
+	if !report.OK() || report.Pools != 1 || report.Ticks != 3 || report.FinishHeight != 100 {
+		t.Fatalf("VerifyDB of a consistent db: got %+v, %v", report, report.Violations)
+	}
+
+	// a tick off the spacing that leaves the sum non-zero, a tick beyond MaxTick
+	if err = db.SetTickState(good, &TickState{Tick: 90, LiquidityNet: big.NewInt(1)}); err != nil {
+		t.Fatalf("SetTickState failed: %v", err)
+	}
+	if err = db.SetTickState(good, &TickState{Tick: MaxTick + 8, LiquidityNet: big.NewInt(-1)}); err != nil {
+		t.Fatalf("SetTickState failed: %v", err)
+	}
+	if err = db.SetHeight(good, 101); err != nil {
+		t.Fatalf("SetHeight failed: %v", err)
+	}
+	if err = db.SetTickState(good, &TickState{Tick: 240, LiquidityNet: big.NewInt(3)}); err != nil {
+		t.Fatalf("SetTickState failed: %v", err)
+	}
+
+	// ticks without metadata and a key too short to decode
+	orphan := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	if err = db.SetTickState(orphan, &TickState{Tick: 60, LiquidityNet: big.NewInt(0)}); err != nil {
+		t.Fatalf("SetTickState failed: %v", err)
+	}
+	if err = store.Set(append(append([]byte{}, KeyPrefixTickState...), 0x01), []byte{0}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	report, err = VerifyDB(store)
+	if err != nil {
+		t.Fatalf("VerifyDB failed: %v", err)
+	}
+
+	got := make(map[string]int)
+	for _, v := range report.Violations {
+		got[v.Check]++
+	}
+	want := map[string]int{
+		CheckKeyDecode:    1,
+		CheckLiquidityNet: 1,
+		CheckTickRange:    1,
+		CheckTickSpacing:  1,
+		CheckPoolMetadata: 1,
+		CheckPoolHeight:   1,
+	}
+	for check, n := range want {
+		if got[check] != n {
+			t.Fatalf("%s: want %d violations, got %d: %v", check, n, got[check], report.Violations)
+		}
+	}
+	if len(report.Violations) != 6 || report.Pools != 2 {
+		t.Fatalf("want 6 violations in 2 pools, got %d in %d", len(report.Violations), report.Pools)
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	if !strings.Contains(out.String(), "liquidity_net: "+good.Hex()) || strings.Contains(out.String(), "\nok\n") {
+		t.Fatalf("Print: got %s", out.String())
+	}
+}